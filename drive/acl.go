@@ -0,0 +1,303 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Permission denotes a capability on a drive.
+type Permission int
+
+const (
+	// PermissionRead permits reading files of the drive.
+	PermissionRead Permission = iota
+
+	// PermissionWrite permits adding and removing files of the drive.
+	PermissionWrite
+
+	// PermissionAdmin permits granting and revoking permissions of the drive.
+	PermissionAdmin
+)
+
+var permissions = []Permission{PermissionRead, PermissionWrite, PermissionAdmin}
+
+// String implements fmt.Stringer interface. It returns the name of the
+// capability recorded in the access controller.
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionWrite:
+		return "write"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+func parsePermission(s string) (Permission, error) {
+	for _, p := range permissions {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown permission %q", s)
+}
+
+// Role denotes a named set of permissions.
+type Role int
+
+const (
+	// RoleReader may read files of the drive.
+	RoleReader Role = iota
+
+	// RoleWriter may read, add and remove files of the drive.
+	RoleWriter
+
+	// RoleAdmin may do everything a writer does, and manage the grants of the
+	// drive.
+	RoleAdmin
+)
+
+// String implements fmt.Stringer interface.
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleWriter:
+		return "writer"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// Permissions returns the permissions of the role.
+func (r Role) Permissions() []Permission {
+	switch r {
+	case RoleReader:
+		return []Permission{PermissionRead}
+	case RoleWriter:
+		return []Permission{PermissionRead, PermissionWrite}
+	case RoleAdmin:
+		return []Permission{PermissionRead, PermissionWrite, PermissionAdmin}
+	default:
+		return nil
+	}
+}
+
+// roleOf returns the role holding exactly given permissions.
+func roleOf(perms map[Permission]bool) Role {
+	switch {
+	case perms[PermissionAdmin]:
+		return RoleAdmin
+	case perms[PermissionWrite]:
+		return RoleWriter
+	default:
+		return RoleReader
+	}
+}
+
+// Grant denotes the permissions granted to an identity. The identity "*" stands
+// for everyone. Role and Permissions cover the whole drive, while Scopes cover
+// only the keys under their prefixes.
+type Grant struct {
+	Identity    string
+	Role        Role
+	Permissions []Permission
+	Scopes      []Scope
+}
+
+// Scope denotes a permission granted on the keys under a prefix.
+type Scope struct {
+	Prefix     string
+	Permission Permission
+}
+
+// PermissionError denotes an operation rejected because the identity lacks the
+// permission. Key is the key being written if the permission is scoped by keys.
+type PermissionError struct {
+	Identity   string
+	Permission Permission
+	Key        string
+}
+
+// Error implements error interface.
+func (e *PermissionError) Error() string {
+	if len(e.Key) != 0 {
+		return fmt.Sprintf("%v: identity %q has no %s permission on key %q", ErrPermissionDenied, e.Identity, e.Permission, e.Key)
+	}
+	return fmt.Sprintf("%v: identity %q has no %s permission", ErrPermissionDenied, e.Identity, e.Permission)
+}
+
+// Is reports whether the error matches ErrPermissionDenied.
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
+
+func (d *drive) Grant(ctx context.Context, identity string, role Role) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if role.Permissions() == nil {
+		return fmt.Errorf("unknown role %d", role)
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+
+	granted, err := d.granted(identity)
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[Permission]bool)
+	for _, p := range role.Permissions() {
+		wanted[p] = true
+	}
+
+	ac := d.kv.AccessController()
+	for _, p := range permissions {
+		switch {
+		case wanted[p] && !granted[p]:
+			err = ac.Grant(ctx, p.String(), identity)
+		case !wanted[p] && granted[p]:
+			err = ac.Revoke(ctx, p.String(), identity)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *drive) Revoke(ctx context.Context, identity string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+
+	granted, err := d.granted(identity)
+	if err != nil {
+		return err
+	}
+
+	ac := d.kv.AccessController()
+	for _, p := range permissions {
+		if !granted[p] {
+			continue
+		}
+		if err := ac.Revoke(ctx, p.String(), identity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *drive) ListGrants(ctx context.Context) ([]Grant, error) {
+	perms := make(map[string]map[Permission]bool)
+
+	ac := d.kv.AccessController()
+	for _, p := range permissions {
+		ids, err := ac.GetAuthorizedByRole(p.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if perms[id] == nil {
+				perms[id] = make(map[Permission]bool)
+			}
+			perms[id][p] = true
+		}
+	}
+
+	var scopes map[string][]Scope
+	if _, ok := ac.(*pathAccessController); ok {
+		var err error
+		scopes, err = d.scopes()
+		if err != nil {
+			return nil, err
+		}
+		for id := range scopes {
+			if perms[id] == nil {
+				perms[id] = make(map[Permission]bool)
+			}
+		}
+	}
+
+	grants := make([]Grant, 0, len(perms))
+	for id, granted := range perms {
+		g := Grant{
+			Identity: id,
+			Role:     roleOf(granted),
+			Scopes:   scopes[id],
+		}
+		for _, p := range permissions {
+			if granted[p] {
+				g.Permissions = append(g.Permissions, p)
+			}
+		}
+		grants = append(grants, g)
+	}
+
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].Identity < grants[j].Identity
+	})
+
+	return grants, nil
+}
+
+// granted returns the permissions granted to the identity itself, not counting
+// the ones granted to everyone.
+func (d *drive) granted(identity string) (map[Permission]bool, error) {
+	granted := make(map[Permission]bool)
+
+	ac := d.kv.AccessController()
+	for _, p := range permissions {
+		ids, err := ac.GetAuthorizedByRole(p.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if id == identity {
+				granted[p] = true
+			}
+		}
+	}
+
+	return granted, nil
+}
+
+// checkWrite verifies that the identity of the instance may write the key. It
+// returns a *PermissionError otherwise.
+func (d *drive) checkWrite(key string) error {
+	if ac, ok := d.kv.AccessController().(*pathAccessController); ok {
+		return ac.authorize(context.Background(), d.Identity(), key)
+	}
+	return d.checkPermission(PermissionWrite)
+}
+
+// checkPermission verifies that the identity of the instance, or everyone, has
+// been granted the permission. It returns a *PermissionError otherwise.
+func (d *drive) checkPermission(p Permission) error {
+	ids, err := d.kv.AccessController().GetAuthorizedByRole(p.String())
+	if err != nil {
+		return err
+	}
+
+	identity := d.Identity()
+	for _, id := range ids {
+		if id == identity || id == "*" {
+			return nil
+		}
+	}
+
+	return &PermissionError{Identity: identity, Permission: p}
+}
@@ -0,0 +1,232 @@
+package drive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	driveopts "github.com/meowdada/ipfstor/options"
+)
+
+// ArchiveFormat denotes the format of an archive.
+type ArchiveFormat int
+
+const (
+	// ArchiveTar denotes a tar archive.
+	ArchiveTar ArchiveFormat = iota
+
+	// ArchiveZip denotes a zip archive.
+	ArchiveZip
+)
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+func (d *drive) ExportArchive(ctx context.Context, prefix string, w io.Writer, format ArchiveFormat) error {
+	lr, err := d.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	var files []File
+	for _, f := range lr.Files() {
+		if inDir(f.Key, prefix) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	switch format {
+	case ArchiveTar:
+		return d.exportTar(ctx, prefix, files, w)
+	case ArchiveZip:
+		return d.exportZip(ctx, prefix, files, w)
+	default:
+		return fmt.Errorf("unsupported archive format: %d", format)
+	}
+}
+
+func (d *drive) exportTar(ctx context.Context, prefix string, files []File, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	for _, f := range files {
+		mtime, _ := f.modTime()
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     archiveName(prefix, f.Key),
+			Size:     f.Size,
+			Mode:     0644,
+			ModTime:  mtime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := d.copyTo(ctx, f.Key, tw); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func (d *drive) exportZip(ctx context.Context, prefix string, files []File, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	for _, f := range files {
+		mtime, _ := f.modTime()
+		hdr := &zip.FileHeader{
+			Name:     archiveName(prefix, f.Key),
+			Method:   zip.Deflate,
+			Modified: mtime,
+		}
+		hdr.SetMode(0644)
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := d.copyTo(ctx, f.Key, fw); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (d *drive) copyTo(ctx context.Context, key string, w io.Writer) error {
+	rc, err := d.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+func (d *drive) ImportArchive(ctx context.Context, prefix string, r io.Reader) ([]File, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return d.importZip(ctx, prefix, br)
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return d.importTar(ctx, prefix, gr)
+	default:
+		return d.importTar(ctx, prefix, br)
+	}
+}
+
+func (d *drive) importTar(ctx context.Context, prefix string, r io.Reader) ([]File, error) {
+	tr := tar.NewReader(r)
+
+	var added []File
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		f, err := d.importEntry(ctx, prefix, hdr.Name, hdr.ModTime, tr)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, f)
+	}
+}
+
+func (d *drive) importZip(ctx context.Context, prefix string, r io.Reader) ([]File, error) {
+	// Zip archives keep their directory at the end, so the stream has to be
+	// spooled to a seekable file before it can be read.
+	tmp, err := ioutil.TempFile("", "ipfstor-import-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []File
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return added, err
+		}
+
+		f, err := d.importEntry(ctx, prefix, zf.Name, zf.Modified, rc)
+		rc.Close()
+		if err != nil {
+			return added, err
+		}
+		added = append(added, f)
+	}
+
+	return added, nil
+}
+
+func (d *drive) importEntry(ctx context.Context, prefix, name string, mtime time.Time, r io.Reader) (File, error) {
+	rel := path.Clean("/" + name)[1:]
+	if len(rel) == 0 {
+		return File{}, fmt.Errorf("invalid archive entry name: %q", name)
+	}
+
+	opt := driveopts.Add()
+	if !mtime.IsZero() {
+		opt.SetModTime(mtime)
+	}
+
+	return d.Add(ctx, path.Join(prefix, rel), r, opt)
+}
+
+// archiveName derives the name of an archive entry from the key of a file.
+func archiveName(prefix, key string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if len(rel) == 0 {
+		return path.Base(key)
+	}
+	return rel
+}
@@ -0,0 +1,246 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"go.uber.org/zap"
+)
+
+const defaultBatchWorkers = 8
+
+// BatchItem denotes a file to be added by a batch operation, along with the
+// options to add it with.
+type BatchItem struct {
+	Key     string
+	Reader  io.Reader
+	Options *driveopts.AddOptions
+}
+
+// RemoveItem denotes a file to be removed by a batch operation, along with the
+// options to remove it with.
+type RemoveItem struct {
+	Key     string
+	Options *driveopts.RemoveOptions
+}
+
+// BatchResult denotes the outcome of a single item of a batch operation.
+type BatchResult struct {
+	Key  string
+	File File
+	Err  error
+}
+
+// BatchError denotes an error that indicates some items of a batch operation
+// failed. The cause of each failure is reported in the corresponding BatchResult.
+type BatchError struct {
+	Failed int
+	Total  int
+}
+
+// Error implements error interface.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d batch operations failed", e.Failed, e.Total)
+}
+
+func (d *drive) AddBatch(ctx context.Context, items []BatchItem, opts ...*driveopts.BatchOptions) ([]BatchResult, error) {
+	opt := driveopts.MergeBatchOptions(opts...)
+
+	results := make([]BatchResult, len(items))
+	addOpts := make([]*driveopts.AddOptions, len(items))
+	for i := range items {
+		results[i].Key = items[i].Key
+		addOpts[i] = driveopts.MergeAddOptions(items[i].Options)
+	}
+
+	process := func(i int) error {
+		if len(items[i].Key) == 0 {
+			return ErrEmptyKey
+		}
+		if items[i].Reader == nil {
+			return fmt.Errorf("input stream is a nil pointer")
+		}
+
+		node := newFile(items[i].Key, items[i].Reader)
+		f, err := d.addContent(ctx, items[i].Key, node, addOpts[i])
+		if err != nil {
+			return err
+		}
+		results[i].File = f
+		return nil
+	}
+
+	// The content of rejected files is released at once after the batch, rather
+	// than scanning the references of the drive for each of them.
+	var rejected []cid.Cid
+	commit := func(ready []int) []error {
+		errs := make([]error, len(ready))
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		for j, i := range ready {
+			errs[j] = d.put(ctx, results[i].File, addOpts[i])
+			if errs[j] != nil {
+				rejected = append(rejected, results[i].File.Cid)
+			}
+		}
+		return errs
+	}
+
+	errs := runBatch(ctx, len(items), batchWorkers(opt), process, commit)
+	if err := d.releaseAll(ctx, rejected); err != nil {
+		d.logger.Warn("failed to unpin rejected content", zap.Error(err))
+	}
+	return collectBatchResults(results, errs)
+}
+
+func (d *drive) RemoveBatch(ctx context.Context, items []RemoveItem, opts ...*driveopts.BatchOptions) ([]BatchResult, error) {
+	opt := driveopts.MergeBatchOptions(opts...)
+
+	results := make([]BatchResult, len(items))
+	removeOpts := make([]*driveopts.RemoveOptions, len(items))
+	for i := range items {
+		results[i].Key = items[i].Key
+		removeOpts[i] = driveopts.MergeRemoveOptions(items[i].Options)
+	}
+
+	process := func(i int) error {
+		if d.readOnly {
+			return ErrReadOnly
+		}
+		if len(items[i].Key) == 0 {
+			return ErrEmptyKey
+		}
+		if isReserved(items[i].Key) {
+			return ErrReservedKey
+		}
+		return d.checkWrite(items[i].Key)
+	}
+
+	// The content of removed files is released at once after the batch.
+	var removed []cid.Cid
+	commit := func(ready []int) []error {
+		errs := make([]error, len(ready))
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		for j, i := range ready {
+			f, trashed, err := d.remove(ctx, items[i].Key, removeOpts[i])
+			if err != nil {
+				errs[j] = err
+				continue
+			}
+			results[i].File = f
+			if !trashed {
+				removed = append(removed, f.Cid)
+			}
+		}
+		return errs
+	}
+
+	errs := runBatch(ctx, len(items), batchWorkers(opt), process, commit)
+	if err := d.releaseAll(ctx, removed); err != nil {
+		d.logger.Warn("failed to unpin removed content", zap.Error(err))
+	}
+	return collectBatchResults(results, errs)
+}
+
+// runBatch processes n items with a pool of workers. The process function is
+// called concurrently by the workers, while the commit function is called by
+// a single goroutine with the items processed successfully and not committed
+// yet, so that metadata is written as soon as its content is ready, and the
+// items ready at the same time are committed together. The commit function
+// returns the errors of the items in the same order.
+func runBatch(ctx context.Context, n, workers int, process func(i int) error, commit func(ready []int) []error) []error {
+	errs := make([]error, n)
+	jobs := make(chan int)
+	done := make(chan int, n)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+				} else {
+					errs[i] = process(i)
+				}
+				done <- i
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < n; i++ {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	for i := range done {
+		ready := appendReady(nil, errs, i)
+
+		// Take the other items processed meanwhile without waiting for more.
+		for drained := false; !drained; {
+			select {
+			case j, ok := <-done:
+				if ok {
+					ready = appendReady(ready, errs, j)
+				} else {
+					drained = true
+				}
+			default:
+				drained = true
+			}
+		}
+
+		if len(ready) == 0 {
+			continue
+		}
+		for j, err := range commit(ready) {
+			errs[ready[j]] = err
+		}
+	}
+
+	return errs
+}
+
+// appendReady appends the i-th item to ready if it has been processed successfully.
+func appendReady(ready []int, errs []error, i int) []int {
+	if errs[i] != nil {
+		return ready
+	}
+	return append(ready, i)
+}
+
+func collectBatchResults(results []BatchResult, errs []error) ([]BatchResult, error) {
+	failed := 0
+	for i := range errs {
+		if errs[i] != nil {
+			results[i].Err = errs[i]
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return results, &BatchError{Failed: failed, Total: len(results)}
+	}
+	return results, nil
+}
+
+func batchWorkers(opt *driveopts.BatchOptions) int {
+	if opt.Workers != nil {
+		return *opt.Workers
+	}
+	return defaultBatchWorkers
+}
@@ -0,0 +1,183 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/car"
+
+	// Register decoders of dag-pb, raw and dag-cbor blocks.
+	_ "github.com/ipfs/go-merkledag"
+)
+
+// carBatchSize is the number of blocks added to ipfs at once while importing.
+const carBatchSize = 128
+
+func (d *drive) ExportCAR(ctx context.Context, w io.Writer) error {
+	m, err := d.manifest()
+	if err != nil {
+		return err
+	}
+
+	node, err := m.node()
+	if err != nil {
+		return err
+	}
+
+	cw, err := car.NewWriter(w, []cid.Cid{node.Cid()})
+	if err != nil {
+		return err
+	}
+	if err := cw.Put(node.Cid(), node.RawData()); err != nil {
+		return err
+	}
+
+	visited := make(map[cid.Cid]bool)
+	for _, e := range m.Files {
+		if err := d.exportDAG(ctx, cw, e.Cid, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportDAG writes every block of the DAG under root which has not been
+// visited yet.
+func (d *drive) exportDAG(ctx context.Context, cw *car.Writer, root cid.Cid, visited map[cid.Cid]bool) error {
+	dag := d.api.Dag()
+
+	stack := []cid.Cid{root}
+	for len(stack) != 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if visited[c] {
+			continue
+		}
+		visited[c] = true
+
+		node, err := dag.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := cw.Put(c, node.RawData()); err != nil {
+			return err
+		}
+
+		for _, link := range node.Links() {
+			stack = append(stack, link.Cid)
+		}
+	}
+
+	return nil
+}
+
+// ImportCAR loads the blocks of a CAR file exported by ExportCAR into the ipfs
+// node, and recreates a drive with given name holding the same keys and cids.
+// The content of every file is pinned as if it was added to the drive.
+func ImportCAR(ctx context.Context, api coreiface.CoreAPI, r io.Reader, name string, opts ...*options.OpenDriveOptions) (Instance, error) {
+	if api == nil {
+		return nil, fmt.Errorf("accepts only non-nil ipfs instance")
+	}
+
+	m, err := loadCAR(ctx, api, r)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, options.OpenDrive().SetCreate(true))
+	inst, err := Open(ctx, api, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	d := inst.(*drive)
+
+	for _, e := range m.Files {
+		if err := d.pin(ctx, e.Cid, e.Key); err != nil {
+			d.Close(ctx)
+			return nil, err
+		}
+		if _, err := d.kv.Put(ctx, e.Key, e.Meta); err != nil {
+			d.Close(ctx)
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// loadCAR adds every block of the CAR file to ipfs and returns the manifest.
+func loadCAR(ctx context.Context, api coreiface.CoreAPI, r io.Reader) (*manifest, error) {
+	cr, err := car.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Header.Roots) != 1 {
+		return nil, fmt.Errorf("expect a single root but get %d", len(cr.Header.Roots))
+	}
+	root := cr.Header.Roots[0]
+
+	var (
+		m     *manifest
+		batch []ipld.Node
+	)
+
+	dag := api.Dag()
+	for {
+		c, data, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Verify the block, since a CAR file might come from anywhere.
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !sum.Equals(c) {
+			return nil, fmt.Errorf("block %s does not match its content", c)
+		}
+
+		if c.Equals(root) {
+			if m, err = decodeManifest(data); err != nil {
+				return nil, err
+			}
+		}
+
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return nil, err
+		}
+		node, err := ipld.Decode(blk)
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, node)
+		if len(batch) >= carBatchSize {
+			if err := dag.AddMany(ctx, batch); err != nil {
+				return nil, err
+			}
+			batch = nil
+		}
+	}
+
+	if err := dag.AddMany(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("manifest %s is missing", root)
+	}
+	return m, nil
+}
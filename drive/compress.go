@@ -0,0 +1,120 @@
+package drive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/meowdada/ipfstor/pkg/compress"
+)
+
+// sniffLen is the number of leading bytes inspected to tell whether content is
+// compressed already.
+const sniffLen = 512
+
+// compression describes content compressed while being added.
+type compression struct {
+	algorithm string
+
+	// src counts the bytes read from the original content.
+	src *countingReader
+}
+
+// compressContent returns the content to add for the node, which is compressed
+// with given algorithm unless the algorithm is empty or the content looks to be
+// compressed already. The returned compression is nil if the content is added as
+// is, otherwise the returned node must be closed once it is consumed.
+func compressContent(key string, node files.Node, algorithm string) (files.Node, *compression, error) {
+	if len(algorithm) == 0 {
+		return node, nil, nil
+	}
+
+	c, err := compress.Get(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f := files.ToFile(node)
+	if f == nil {
+		return nil, nil, fmt.Errorf("cannot compress content of %q which is not a file", key)
+	}
+
+	br := bufio.NewReaderSize(f, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if !compress.Compressible(key, head) {
+		return newFile(key, br), nil, nil
+	}
+
+	content, src := compressNode(key, br, c)
+	return content, &compression{algorithm: algorithm, src: src}, nil
+}
+
+// compressNode returns a node streaming the content of r compressed by c, along
+// with the counter of bytes read from r.
+func compressNode(key string, r io.Reader, c compress.Instance) (files.Node, *countingReader) {
+	src := &countingReader{r: r}
+	pr, pw := io.Pipe()
+
+	go func() {
+		w, err := c.Compress(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, src); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	return newFile(key, pr), src
+}
+
+// decompressFile wraps the content of a file compressed with given algorithm, so
+// that it is decompressed while being read.
+func decompressFile(rc io.ReadCloser, algorithm string) (io.ReadCloser, error) {
+	c, err := compress.Get(algorithm)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	r, err := c.Decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &decompressReader{ReadCloser: r, src: rc}, nil
+}
+
+// decompressReader closes both the decompressor and the underlying content.
+type decompressReader struct {
+	io.ReadCloser
+	src io.Closer
+}
+
+func (r *decompressReader) Close() error {
+	err := r.ReadCloser.Close()
+	if srcErr := r.src.Close(); err == nil {
+		err = srcErr
+	}
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
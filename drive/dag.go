@@ -0,0 +1,115 @@
+package drive
+
+import (
+	"fmt"
+
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	driveopts "github.com/meowdada/ipfstor/options"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	// defaultChunker is the chunker used by ipfs by default.
+	defaultChunker = "size-262144"
+
+	// defaultHash is the hash function used by ipfs by default.
+	defaultHash = "sha2-256"
+)
+
+// DAGParams denotes how the content of a file was chunked and laid out as a DAG.
+// It is zero for files added before the parameters were recorded, which were
+// added with the defaults of ipfs.
+//
+// Parts is the number of parts whose DAGs were stitched into the DAG of a file
+// completed by CompleteUpload, which is zero for files added at once. The DAG of
+// stitched parts cannot be reproduced by adding the content again.
+type DAGParams struct {
+	Chunker    string
+	Layout     string
+	RawLeaves  bool
+	CidVersion int
+	Hash       string
+	Parts      int
+}
+
+// options returns the DAGOptions which reproduce the DAG of the parameters.
+func (p DAGParams) options() *driveopts.DAGOptions {
+	if p == (DAGParams{}) {
+		return driveopts.DAG()
+	}
+	return driveopts.DAG().
+		SetChunker(p.Chunker).
+		SetLayout(p.Layout).
+		SetRawLeaves(p.RawLeaves).
+		SetCidVersion(p.CidVersion).
+		SetHash(p.Hash)
+}
+
+// dagParams resolves the DAGOptions into the parameters recorded in files, along
+// with the options to add content with them. Defaults are resolved in the same
+// way as ipfs does.
+func dagParams(opt *driveopts.DAGOptions) (DAGParams, []options.UnixfsAddOption, error) {
+	p := DAGParams{
+		Chunker: defaultChunker,
+		Layout:  driveopts.LayoutBalanced,
+		Hash:    defaultHash,
+	}
+
+	var unixfsOpts []options.UnixfsAddOption
+
+	if opt.Chunker != nil {
+		p.Chunker = *opt.Chunker
+		unixfsOpts = append(unixfsOpts, options.Unixfs.Chunker(p.Chunker))
+	}
+
+	if opt.Layout != nil {
+		switch *opt.Layout {
+		case driveopts.LayoutBalanced:
+			unixfsOpts = append(unixfsOpts, options.Unixfs.Layout(options.BalancedLayout))
+		case driveopts.LayoutTrickle:
+			unixfsOpts = append(unixfsOpts, options.Unixfs.Layout(options.TrickleLayout))
+		default:
+			return DAGParams{}, nil, fmt.Errorf("unknown layout %q", *opt.Layout)
+		}
+		p.Layout = *opt.Layout
+	}
+
+	if opt.Hash != nil {
+		code, ok := mh.Names[*opt.Hash]
+		if !ok {
+			return DAGParams{}, nil, fmt.Errorf("unknown hash function %q", *opt.Hash)
+		}
+		p.Hash = *opt.Hash
+		unixfsOpts = append(unixfsOpts, options.Unixfs.Hash(code))
+	}
+
+	version := -1
+	if opt.CidVersion != nil {
+		version = *opt.CidVersion
+		if version != 0 && version != 1 {
+			return DAGParams{}, nil, fmt.Errorf("unknown CID version %d", version)
+		}
+		unixfsOpts = append(unixfsOpts, options.Unixfs.CidVersion(version))
+	}
+
+	// CIDv0 supports only sha2-256, and other hash functions imply CIDv1.
+	if p.Hash != defaultHash {
+		if version == 0 {
+			return DAGParams{}, nil, fmt.Errorf("CID version 0 supports only %s", defaultHash)
+		}
+		version = 1
+	}
+	if version < 0 {
+		version = 0
+	}
+	p.CidVersion = version
+
+	// CIDv1 implies raw leaves unless specified.
+	p.RawLeaves = version == 1
+	if opt.RawLeaves != nil {
+		p.RawLeaves = *opt.RawLeaves
+		unixfsOpts = append(unixfsOpts, options.Unixfs.RawLeaves(p.RawLeaves))
+	}
+
+	return p, unixfsOpts, nil
+}
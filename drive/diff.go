@@ -0,0 +1,286 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/format"
+)
+
+// compareChunkSize is the size of the chunks to compare contents by.
+const compareChunkSize = 32 * 1024
+
+// ChangeType denotes the kind of a change between two states of a drive.
+type ChangeType int
+
+const (
+	// ChangeAdded denotes a file presenting only in the latter state.
+	ChangeAdded ChangeType = iota
+
+	// ChangeRemoved denotes a file presenting only in the former state.
+	ChangeRemoved
+
+	// ChangeModified denotes a file whose content is changed.
+	ChangeModified
+
+	// ChangeMetadata denotes a file whose content is the same but whose
+	// metadata is changed.
+	ChangeMetadata
+)
+
+// String implements fmt.Stringer interface.
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	case ChangeMetadata:
+		return "metadata"
+	default:
+		return "unknown"
+	}
+}
+
+// Change denotes the change of a single file. From is the zero value if the
+// file is added, and To is the zero value if the file is removed.
+type Change struct {
+	Type ChangeType
+	Key  string
+	From File
+	To   File
+}
+
+// DiffResult denotes a data structure contains result of diff operation.
+type DiffResult struct {
+	changes []Change
+}
+
+// Changes returns all changes sorted by key.
+func (dr *DiffResult) Changes() []Change {
+	return dr.changes
+}
+
+// Bytes marshals the result into bytes.
+func (dr *DiffResult) Bytes() []byte {
+	rows := make([]format.Row, len(dr.changes))
+
+	for i, c := range dr.changes {
+		rows[i] = format.Row{
+			{Key: "Change", Value: c.Type},
+			{Key: "Key", Value: c.Key},
+			{Key: "From", Value: cidString(c.From.Cid)},
+			{Key: "To", Value: cidString(c.To.Cid)},
+		}
+	}
+
+	tmpl := format.Basic{}
+	return tmpl.Render(rows, format.Options{})
+}
+
+// Diff compares the files under the directory of prefix between two drive
+// instances, such as two snapshots or two drives opened by their addresses.
+func Diff(ctx context.Context, from, to Instance, prefix string) (DiffResult, error) {
+	a, err := listPrefix(ctx, from, prefix)
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	b, err := listPrefix(ctx, to, prefix)
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	return diffFiles(a, b), nil
+}
+
+func (d *drive) DiffDir(ctx context.Context, prefix, localDir string) (DiffResult, error) {
+	a, err := listPrefix(ctx, d, prefix)
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	b := make(map[string]File)
+	w := &dirWalker{
+		symlinks: driveopts.SymlinkSkip,
+		visited:  make(map[string]bool),
+	}
+
+	err = w.walk(localDir, "", func(rel, fpath string, info os.FileInfo) error {
+		key := path.Join(prefix, rel)
+
+		// Hash the file in the same way as the one in the drive, or as it would
+		// be added if absent.
+		dag, algorithm := d.dag, d.compression
+		f, ok := a[key]
+		if ok {
+			dag, algorithm = f.DAG.options(), f.Compression
+		}
+
+		c, err := d.hashFile(ctx, key, fpath, dag, algorithm)
+		if err != nil {
+			return err
+		}
+
+		// The DAG of stitched parts cannot be reproduced, compare the content
+		// instead.
+		if ok && f.DAG.Parts > 0 && f.Size == info.Size() {
+			same, err := d.sameContent(ctx, f, fpath)
+			if err != nil {
+				return err
+			}
+			if same {
+				c = f.Cid
+			}
+		}
+
+		b[key] = File{
+			Key:     key,
+			Cid:     c,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC().Format(time.RFC1123),
+		}
+		return nil
+	})
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	return diffFiles(a, b), nil
+}
+
+// hashFile computes the cid a local file would have once added to the drive with
+// given key, DAG options and compression algorithm, without storing it.
+func (d *drive) hashFile(ctx context.Context, key, fpath string, dag *driveopts.DAGOptions, algorithm string) (cid.Cid, error) {
+	_, unixfsOpts, err := dagParams(driveopts.MergeDAGOptions(dag))
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	node, _, err := openFileNode(fpath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer node.Close()
+
+	content, compressed, err := compressContent(key, node, algorithm)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if compressed != nil {
+		defer content.Close()
+	}
+
+	unixfsOpts = append(unixfsOpts, options.Unixfs.HashOnly(true))
+	resolve, err := d.api.Unixfs().Add(ctx, content, unixfsOpts...)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return resolve.Cid(), nil
+}
+
+// sameContent reports whether the file in the drive holds the same content as
+// the local file. The contents are compared chunk by chunk without holding either
+// of them in memory.
+func (d *drive) sameContent(ctx context.Context, f File, fpath string) (bool, error) {
+	local, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer local.Close()
+
+	rc, err := d.Get(ctx, f.Key)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+	for {
+		na, errA := io.ReadFull(local, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nb, errB := io.ReadFull(rc, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
+
+func listPrefix(ctx context.Context, d Instance, prefix string) (map[string]File, error) {
+	lr, err := d.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]File)
+	for _, f := range lr.Files() {
+		if inDir(f.Key, prefix) {
+			files[f.Key] = f
+		}
+	}
+	return files, nil
+}
+
+func diffFiles(a, b map[string]File) DiffResult {
+	var changes []Change
+
+	for k, from := range a {
+		to, ok := b[k]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Type: ChangeRemoved, Key: k, From: from})
+		case !from.Cid.Equals(to.Cid):
+			changes = append(changes, Change{Type: ChangeModified, Key: k, From: from, To: to})
+		case !sameMetadata(from, to):
+			changes = append(changes, Change{Type: ChangeMetadata, Key: k, From: from, To: to})
+		}
+	}
+
+	for k, to := range b {
+		if _, ok := a[k]; !ok {
+			changes = append(changes, Change{Type: ChangeAdded, Key: k, To: to})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return DiffResult{changes: changes}
+}
+
+// sameMetadata compares the metadata of two files holding the same content.
+// Fields which are not recorded by either side are ignored.
+func sameMetadata(a, b File) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	if !sameField(a.Owner, b.Owner) || !sameField(a.ModTime, b.ModTime) {
+		return false
+	}
+	return true
+}
+
+func sameField(a, b string) bool {
+	return len(a) == 0 || len(b) == 0 || a == b
+}
@@ -0,0 +1,212 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	driveopts "github.com/meowdada/ipfstor/options"
+)
+
+func (d *drive) AddDir(ctx context.Context, prefix, localDir string, opts ...*driveopts.AddDirOptions) ([]File, error) {
+	opt := driveopts.MergeAddDirOptions(opts...)
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
+	w := &dirWalker{
+		ignore:   opt.Ignore,
+		symlinks: driveopts.SymlinkSkip,
+		visited:  make(map[string]bool),
+	}
+	if opt.Symlinks != nil {
+		w.symlinks = *opt.Symlinks
+	}
+
+	var added []File
+	err := w.walk(localDir, "", func(rel, fpath string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		addOpt := driveopts.Add().SetModTime(info.ModTime())
+		f, err := d.AddFile(ctx, path.Join(prefix, rel), fpath, addOpt)
+		if err != nil {
+			return err
+		}
+
+		added = append(added, f)
+		return nil
+	})
+
+	return added, err
+}
+
+func (d *drive) GetDir(ctx context.Context, prefix, localDir string) ([]File, error) {
+	lr, err := d.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Clean(localDir)
+
+	var fetched []File
+	for _, f := range lr.Files() {
+		if !inDir(f.Key, prefix) {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(f.Key, prefix), "/")
+		if len(rel) == 0 {
+			rel = path.Base(f.Key)
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !strings.HasPrefix(target, root+string(filepath.Separator)) {
+			return fetched, fmt.Errorf("key %q escapes the target directory", f.Key)
+		}
+
+		if err := d.getFile(ctx, f, target); err != nil {
+			return fetched, err
+		}
+
+		fetched = append(fetched, f)
+	}
+
+	return fetched, nil
+}
+
+// inDir reports whether the key is the prefix itself or lies in the directory of
+// the prefix, so that "docs" matches "docs/a" but not "docs2/a".
+func inDir(key, prefix string) bool {
+	if len(prefix) == 0 || strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(key, prefix)
+	}
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// getFile writes the content of the file to target and restores its
+// modification time.
+func (d *drive) getFile(ctx context.Context, f File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	rc, err := d.Get(ctx, f.Key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Leave the time untouched if the metadata carries no valid timestamp.
+	mtime, err := f.modTime()
+	if err != nil {
+		return nil
+	}
+
+	return os.Chtimes(target, mtime, mtime)
+}
+
+// modTime returns the modification time of the file. It falls back to the time
+// the file was added if the modification time was not recorded.
+func (f *File) modTime() (time.Time, error) {
+	if len(f.ModTime) != 0 {
+		return time.Parse(time.RFC1123, f.ModTime)
+	}
+	return time.Parse(time.RFC1123, f.Timestamp)
+}
+
+// dirWalker walks a local directory and visits every regular file under it
+// with respect to ignore patterns and symbolic link policy.
+type dirWalker struct {
+	ignore   []string
+	symlinks driveopts.SymlinkPolicy
+	visited  map[string]bool
+}
+
+type visitFunc func(rel, fpath string, info os.FileInfo) error
+
+func (w *dirWalker) walk(dir, rel string, visit visitFunc) error {
+	// Guard against cycles introduced by followed symbolic links.
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if w.visited[real] {
+		return nil
+	}
+	w.visited[real] = true
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		childRel := path.Join(rel, name)
+		if w.ignored(childRel, name) {
+			continue
+		}
+
+		fpath := filepath.Join(dir, name)
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.symlinks {
+			case driveopts.SymlinkSkip:
+				continue
+			case driveopts.SymlinkReject:
+				return fmt.Errorf("symbolic link is not allowed: %s", fpath)
+			}
+
+			info, err = os.Stat(fpath)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			if err := w.walk(fpath, childRel, visit); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := visit(childRel, fpath, info); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *dirWalker) ignored(rel, name string) bool {
+	for _, pattern := range w.ignore {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
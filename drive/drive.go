@@ -5,18 +5,23 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/go-orbit-db/accesscontroller"
 	"berty.tech/go-orbit-db/baseorbitdb"
 	"berty.tech/go-orbit-db/iface"
 	"github.com/dustin/go-humanize"
 	"github.com/ipfs/go-cid"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/meowdada/ipfstor/ipfsutil"
+	"github.com/meowdada/ipfstor/keystore"
 	"github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pinning"
 	"github.com/meowdada/ipfstor/pkg/format"
 	"github.com/pkg/errors"
 )
@@ -24,6 +29,25 @@ import (
 const (
 	keyvalueStoreType = "keyvalue"
 
+	// defaultDirectory is the directory orbitdb keeps its data by default.
+	defaultDirectory = "./orbitdb"
+
+	// reservedPrefix is the prefix of keys holding internal records of a drive,
+	// which are hidden from listing and cannot be written by users.
+	reservedPrefix = ".ipfstor/"
+
+	snapshotPrefix = reservedPrefix + "snapshots/"
+
+	trashPrefix = reservedPrefix + "trash/"
+
+	lifecyclePrefix = reservedPrefix + "lifecycle/"
+
+	aclPrefix = reservedPrefix + "acl/"
+
+	sharePrefix = reservedPrefix + "shares/"
+
+	treePrefix = reservedPrefix + "trees/"
+
 	// ListMask is a bitmask to determine which value to be printed out.
 	ListMask uint32 = 31
 
@@ -49,8 +73,128 @@ var (
 
 	// ErrEmptyKey denotes an error that indicates using empty key as input argument.
 	ErrEmptyKey = errors.New("empty key is not acceptable")
+
+	// ErrReservedKey denotes an error that indicates using a key within the namespace
+	// reserved for internal records.
+	ErrReservedKey = errors.New("key is reserved for internal use")
+
+	// ErrReadOnly denotes an error that indicates writing to a read-only drive instance.
+	ErrReadOnly = errors.New("drive instance is read-only")
+
+	// ErrNoSuchSnapshot denotes an error that indicates no such snapshot presents.
+	ErrNoSuchSnapshot = errors.New("no such snapshot")
+
+	// ErrSnapshotExists denotes an error that indicates a snapshot with the same label presents.
+	ErrSnapshotExists = errors.New("snapshot already exists")
+
+	// ErrPreconditionFailed denotes an error that indicates a conditional write is
+	// rejected because the current state of the key does not match the expectation.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrQuotaExceeded denotes an error that indicates a write is rejected because
+	// it would exceed the quota of the drive or of the owner.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrPermissionDenied denotes an error that indicates the identity of the instance
+	// lacks the permission of an operation.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrInvalidProvenance denotes an error that indicates the provenance of a file
+	// cannot be verified.
+	ErrInvalidProvenance = errors.New("invalid provenance")
+
+	// ErrNoSuchShare denotes an error that indicates no such share presents.
+	ErrNoSuchShare = errors.New("no such share")
+
+	// ErrInvalidShare denotes an error that indicates a share token is rejected.
+	ErrInvalidShare = errors.New("invalid share")
+
+	// ErrNoSuchUpload denotes an error that indicates no such upload presents.
+	ErrNoSuchUpload = errors.New("no such upload")
+
+	// ErrSizeMismatch denotes an error that indicates the size of added content
+	// differs from the expected one.
+	ErrSizeMismatch = errors.New("size mismatch")
 )
 
+// PreconditionError denotes a conditional write or remove that has been rejected.
+// Expected is cid.Undef if the key was expected to be absent, and Actual is cid.Undef
+// if the key does not present.
+type PreconditionError struct {
+	Key      string
+	Expected cid.Cid
+	Actual   cid.Cid
+}
+
+// Error implements error interface.
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("%v: key %q expects %s but has %s", ErrPreconditionFailed, e.Key, cidString(e.Expected), cidString(e.Actual))
+}
+
+// Is reports whether the error matches ErrPreconditionFailed.
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+// QuotaError denotes a write that has been rejected by a quota. Owner is empty if
+// the quota of the whole drive would be exceeded, and Usage is the usage as if the
+// write were accepted.
+type QuotaError struct {
+	Owner string
+	Quota options.Quota
+	Usage Usage
+}
+
+// Error implements error interface.
+func (e *QuotaError) Error() string {
+	scope := "drive"
+	if len(e.Owner) != 0 {
+		scope = fmt.Sprintf("owner %q", e.Owner)
+	}
+	return fmt.Sprintf("%v: %s would use %d bytes in %d files, limited to %s", ErrQuotaExceeded, scope, e.Usage.Bytes, e.Usage.Files, quotaString(e.Quota))
+}
+
+// Is reports whether the error matches ErrQuotaExceeded.
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// SizeError denotes a write that has been rejected since the size of its content
+// differs from the one expected by the caller.
+type SizeError struct {
+	Key      string
+	Expected int64
+	Actual   int64
+}
+
+// Error implements error interface.
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%v: key %q expects %d bytes but has %d", ErrSizeMismatch, e.Key, e.Expected, e.Actual)
+}
+
+// Is reports whether the error matches ErrSizeMismatch.
+func (e *SizeError) Is(target error) bool {
+	return target == ErrSizeMismatch
+}
+
+func quotaString(q options.Quota) string {
+	var limits []string
+	if q.Bytes > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes", q.Bytes))
+	}
+	if q.Files > 0 {
+		limits = append(limits, fmt.Sprintf("%d files", q.Files))
+	}
+	return strings.Join(limits, " and ")
+}
+
+func cidString(c cid.Cid) string {
+	if !c.Defined() {
+		return "<none>"
+	}
+	return c.String()
+}
+
 // Instance denotes a drive instance.
 type Instance interface {
 	// Name denotes the human readable name of the instance.
@@ -66,28 +210,262 @@ type Instance interface {
 	Identity() string
 
 	// AddFile adds a local file to the drive instance with given key.
-	AddFile(ctx context.Context, key, fpath string) (File, error)
+	AddFile(ctx context.Context, key, fpath string, opts ...*options.AddOptions) (File, error)
 
-	// Add adds a file with given key and a stream reader.
-	Add(ctx context.Context, key string, r io.Reader) (File, error)
+	// Add adds a file with given key and a stream reader. The size of the file is
+	// taken from the UnixFS node of the added content, and a *SizeError is returned
+	// if it differs from the expected size given by the options.
+	Add(ctx context.Context, key string, r io.Reader, opts ...*options.AddOptions) (File, error)
+
+	// PutIf adds a file with given key only if the Cid of the current file
+	// equals to expected. If expected is cid.Undef, the file is added only
+	// if the key does not present yet. A *PreconditionError is returned if
+	// the condition does not hold.
+	//
+	// Preconditions are evaluated against the local replica and serialized
+	// with other writes issued through the same instance. Since orbitdb
+	// replicas converge eventually, two peers may both pass the same
+	// precondition, and the entry written later wins once their logs are
+	// merged.
+	PutIf(ctx context.Context, key string, r io.Reader, expected cid.Cid) (File, error)
+
+	// AddBatch adds multiple files with a pool of workers, each with the options of
+	// its item in the same way as Add. The content of the files is added
+	// concurrently, while their metadata is committed by a single goroutine, which
+	// commits the files ready at the same time together in one critical section
+	// and releases the content of rejected files at once after the batch. The
+	// results are reported in the same order as the items. If any of the items
+	// fails, a *BatchError is returned along with the results.
+	//
+	// The key-value store cannot write multiple keys in a single entry, so the
+	// metadata of each file is still appended to the log as a separate entry.
+	AddBatch(ctx context.Context, items []BatchItem, opts ...*options.BatchOptions) ([]BatchResult, error)
+
+	// AddDir walks a local directory and adds every regular file under it. The key
+	// of each file is its slash separated path relative to localDir joined with
+	// prefix, and its modification time is preserved.
+	AddDir(ctx context.Context, prefix, localDir string, opts ...*options.AddDirOptions) ([]File, error)
 
 	// Get gets a file with given key from the drive instance.
 	Get(ctx context.Context, key string) (io.ReadCloser, error)
 
-	// Stat stats a file with given key from the drive.
-	Stat(ctx context.Context, key string) (File, error)
+	// GetDir writes every file under the directory of prefix to localDir, using the
+	// remaining part of the key as the relative path, so that "docs" covers
+	// "docs/a" but not "docs2/a". The modification time of each file is restored
+	// from its metadata.
+	GetDir(ctx context.Context, prefix, localDir string) ([]File, error)
+
+	// ExportArchive writes every file under the directory of prefix to w as an
+	// archive of given format, using the remaining part of the key as the name
+	// of each entry.
+	ExportArchive(ctx context.Context, prefix string, w io.Writer, format ArchiveFormat) error
+
+	// ImportArchive adds every regular file in the archive read from r with the
+	// key of its name joined with prefix. Tar, gzipped tar and zip archives are
+	// detected automatically.
+	ImportArchive(ctx context.Context, prefix string, r io.Reader) ([]File, error)
+
+	// ExportCAR writes the drive to w as a CARv1 file. The root of the CAR file
+	// is a manifest block carrying the metadata of every file, followed by all
+	// blocks of the files. The CAR file can be loaded by ImportCAR.
+	ExportCAR(ctx context.Context, w io.Writer) error
+
+	// Stat stats a file with given key from the drive. If the drive pins through
+	// a pinning backend, such as an ipfs-cluster, and the options ask for it, the
+	// status of the pin at each place holding the content is reported in the Pins
+	// field.
+	Stat(ctx context.Context, key string, opts ...*options.StatOptions) (File, error)
+
+	// VerifyProvenance verifies that the file with given key was signed by its
+	// owner, and that its key, cid, size and timestamp are not altered since.
+	// A *ProvenanceError is returned along with the file if it does not hold,
+	// including files written by versions without provenance.
+	VerifyProvenance(ctx context.Context, key string) (File, error)
 
 	// List lists all existing files which matches given prefix.
 	List(ctx context.Context, prefix string) (ListResult, error)
 
-	// Remove remove the file from the drive instance.
-	Remove(ctx context.Context, key string) error
-
-	// Grant grants permission to specific user.
-	Grant(ctx context.Context, keyID, permission string) error
-
-	// Revoke revokes permission from spcific user.
-	Revoke(ctx context.Context, keyID, permission string) error
+	// Remove remove the file from the drive instance. If the trash bin of the
+	// drive is enabled, the file is moved to the trash bin and its content stays
+	// pinned until the trash bin is purged.
+	Remove(ctx context.Context, key string, opts ...*options.RemoveOptions) error
+
+	// RemoveBatch removes multiple files, each with the options of its item in the
+	// same way as Remove. The files are removed in the same way as AddBatch commits
+	// them, and the content of files not moved to the trash bin is released at once
+	// after the batch. It reports the results in the same way as AddBatch.
+	RemoveBatch(ctx context.Context, items []RemoveItem, opts ...*options.BatchOptions) ([]BatchResult, error)
+
+	// Grant assigns the role to the identity, granting the permissions of the
+	// role and revoking others. Only admins of the drive may grant roles, and
+	// the access controller of the drive must support granting, such as the
+	// orbitdb access controller.
+	//
+	// Read permissions are recorded for bookkeeping, while anyone who knows
+	// the address of the drive can read it in fact.
+	Grant(ctx context.Context, identity string, role Role) error
+
+	// Revoke revokes all permissions from the identity. Only admins of the
+	// drive may revoke permissions.
+	Revoke(ctx context.Context, identity string) error
+
+	// ListGrants lists the permissions granted to each identity, sorted by
+	// identity.
+	ListGrants(ctx context.Context) ([]Grant, error)
+
+	// GrantPrefix grants the identity the permission on the keys under the
+	// prefix, which must end with a slash. Once an identity is granted any
+	// prefix, it may write only under its prefixes unless it is granted write
+	// permission on the whole drive. Only admins of the drive may grant
+	// prefixes, and the drive must be opened with the path-scoped access
+	// controller.
+	//
+	// The rules are stored in the drive and enforced on both local writes and
+	// entries replicated from peers.
+	GrantPrefix(ctx context.Context, identity, prefix string, perm Permission) error
+
+	// RevokePrefix revokes the permissions granted to the identity on the keys
+	// under the prefix.
+	RevokePrefix(ctx context.Context, identity, prefix string) error
+
+	// ListTrash lists all files in the trash bin, sorted by key and then from the
+	// oldest removal. Every removed version of a key is kept as a separate entry.
+	ListTrash(ctx context.Context) ([]TrashEntry, error)
+
+	// Restore moves the most recently removed version of the file with given key
+	// back from the trash bin. It fails with a *PreconditionError if the key
+	// presents in the drive.
+	Restore(ctx context.Context, key string) (File, error)
+
+	// PurgeTrash unpins and deletes files which have stayed in the trash bin
+	// longer than olderThan, and returns them.
+	PurgeTrash(ctx context.Context, olderThan time.Duration) ([]TrashEntry, error)
+
+	// SetLifecycleRule sets the lifecycle rule of the prefix of given rule. The
+	// rule is stored in the drive, so it is shared with all peers. It requires
+	// PermissionAdmin, since a rule may expire any file of the drive.
+	SetLifecycleRule(ctx context.Context, rule LifecycleRule) error
+
+	// RemoveLifecycleRule removes the lifecycle rule of given prefix. It requires
+	// PermissionAdmin as well.
+	RemoveLifecycleRule(ctx context.Context, prefix string) error
+
+	// ListLifecycleRules lists all lifecycle rules of the drive.
+	ListLifecycleRules(ctx context.Context) ([]LifecycleRule, error)
+
+	// Expire removes and unpins files which are expired by their expiry time or
+	// by lifecycle rules, and returns them. Files which the identity of the
+	// instance cannot write, or which are changed meanwhile, are skipped. It is
+	// what the janitor of the drive runs periodically.
+	Expire(ctx context.Context) ([]File, error)
+
+	// Snapshot records all files presenting in the drive as an immutable IPLD
+	// object with given label, and returns its cid. The content of the files
+	// stays pinned as long as the snapshot presents.
+	Snapshot(ctx context.Context, label string) (cid.Cid, error)
+
+	// ListSnapshots lists all snapshots of the drive.
+	ListSnapshots(ctx context.Context) ([]Snapshot, error)
+
+	// OpenSnapshot opens the snapshot with given label as a read-only drive
+	// instance. Closing the returned instance does not close this one.
+	OpenSnapshot(ctx context.Context, label string) (Instance, error)
+
+	// RollbackTo restores the files of the drive to the snapshot with given
+	// label. Files added after the snapshot was taken are removed in the same
+	// way as Remove, so they are moved to the trash bin if it is enabled. It
+	// fails without changing anything if the identity of the instance cannot
+	// write any of the keys involved, or if the restored files exceed a quota.
+	RollbackTo(ctx context.Context, label string) error
+
+	// Usage reports the storage usage of the drive and of each owner, along with
+	// the quotas configured for the instance. Files in the trash bin are not
+	// counted.
+	//
+	// Quotas are enforced by the instance which writes the file, against its own
+	// replica. Usage is tracked incrementally from local writes, and refreshed
+	// with replicated entries whenever Usage is called.
+	Usage(ctx context.Context) (UsageReport, error)
+
+	// Stats collects statistics of the files presenting in the drive, such as the
+	// logical and deduplicated sizes, and breakdowns by owner and by prefix.
+	Stats(ctx context.Context, opts ...*options.StatsOptions) (Stats, error)
+
+	// DiffDir compares the files under the directory of prefix against a local
+	// directory, as if the directory was added by AddDir with the same prefix.
+	// Files presenting only in the local directory are reported as added. Files
+	// completed from multiple upload parts are compared by their content, since
+	// their DAG cannot be reproduced from the local file.
+	DiffDir(ctx context.Context, prefix, localDir string) (DiffResult, error)
+
+	// Share shares the file with given key, or the files under the key if it ends
+	// with a slash, as a read-only view. By default, a directory DAG of the files
+	// is published to IPNS with a key generated for the share. If the Token option
+	// is set, a capability token signed by the identity of the instance is issued
+	// instead, which is checked by VerifyShare. The expiry time of the share, if
+	// any, must be in the future.
+	//
+	// IPNS shares are snapshots of the files at the time of sharing, while tokens
+	// grant access to the current files under the key until revoked.
+	Share(ctx context.Context, key string, opts ...*options.ShareOptions) (Share, error)
+
+	// Unshare revokes the share with given id. The IPNS name of the share is
+	// published with an empty directory before its key is removed, and since the
+	// key is held by the ipfs node which shared it, only that node can revoke an
+	// IPNS share.
+	Unshare(ctx context.Context, id string) error
+
+	// ListShares lists all shares of the drive, sorted by key.
+	ListShares(ctx context.Context) ([]Share, error)
+
+	// VerifyShare verifies a capability token issued by Share, and returns the
+	// share it grants. It fails with ErrInvalidShare if the token is forged, is
+	// issued for another drive, has expired or has been revoked. Callers serving
+	// the content of the drive by tokens, such as an HTTP gateway, must restrict
+	// it to the Key of the returned share.
+	VerifyShare(ctx context.Context, token string) (Share, error)
+
+	// PublishTree builds a UnixFS directory DAG mirroring the hierarchy of the keys
+	// under prefix, which must be empty or end with a slash, and returns its root.
+	// The tree links the existing content of the files, so nothing is added again,
+	// except for compressed files, whose decompressed content is added to the tree.
+	// If the Key option is set, the root is published to IPNS with the key, so the
+	// tree can be browsed through any ipfs gateway.
+	//
+	// The tree stays pinned until another tree is published for the same prefix.
+	// Files whose keys cannot be represented as paths, such as ones with empty
+	// segments, are left out of the tree.
+	PublishTree(ctx context.Context, prefix string, opts ...*options.PublishTreeOptions) (cid.Cid, error)
+
+	// BeginUpload begins a resumable upload of a file with given key, whose
+	// content is uploaded in parts by UploadPart. The options are applied when
+	// the upload is completed, and the ExpectedSize option is checked against the
+	// total size of the parts. The content of uploads is not compressed, so the
+	// Compression option is rejected.
+	//
+	// The state of the upload is saved in the drive directory, so the upload
+	// can be resumed by the instance opened from the same directory after the
+	// process restarts. The state is not shared with other peers.
+	BeginUpload(ctx context.Context, key string, opts ...*options.AddOptions) (Upload, error)
+
+	// UploadPart adds the n-th part of the upload, starting from 1. Each part is
+	// added to ipfs as a separate DAG and pinned until the upload is completed
+	// or aborted. Uploading a part with the same number again replaces it. The
+	// part is rejected with a *QuotaError if the parts uploaded so far exceed the
+	// quota of the drive.
+	UploadPart(ctx context.Context, id string, n int, r io.Reader) (Part, error)
+
+	// CompleteUpload stitches the parts of the upload in the order of their
+	// numbers into a single file DAG, and adds it to the drive. If the file is
+	// rejected, such as by a precondition or a quota, the upload is kept so
+	// that it can be completed again or aborted.
+	CompleteUpload(ctx context.Context, id string) (File, error)
+
+	// AbortUpload discards the upload and unpins its parts.
+	AbortUpload(ctx context.Context, id string) error
+
+	// ListUploads lists the pending uploads of the instance, sorted by key.
+	ListUploads(ctx context.Context) ([]Upload, error)
 
 	// Close closes the drive instance and save the snapshot of the drive.
 	Close(ctx context.Context) error
@@ -147,6 +525,76 @@ func (lr *ListResult) Files() []File {
 	return lr.files
 }
 
+// Usage denotes the storage usage by the sizes and the number of files.
+type Usage struct {
+	Bytes int64
+	Files int64
+}
+
+// UsageReport denotes the storage usage of a drive.
+type UsageReport struct {
+	Total       Usage
+	Owners      map[string]Usage
+	Quota       options.Quota
+	OwnerQuotas map[string]options.Quota
+}
+
+// LifecycleRule denotes the rule to expire files whose keys start with Prefix.
+// Files older than ExpireAfter are expired if it is positive. If KeepLast is
+// positive, only the KeepLast most recently added files are kept and others
+// are expired.
+type LifecycleRule struct {
+	Prefix      string
+	ExpireAfter time.Duration
+	KeepLast    int
+}
+
+// TrashEntry denotes a file in the trash bin. DeletedAt is formatted in RFC1123
+// in UTC.
+type TrashEntry struct {
+	File      File
+	DeletedAt string
+}
+
+// Snapshot denotes a named, immutable point-in-time record of a drive.
+type Snapshot struct {
+	Label     string
+	Cid       cid.Cid
+	Timestamp string
+	Owner     string
+}
+
+// Share denotes a read-only view of a file, or of the files under a prefix, shared
+// to someone outside the drive. A share is either published to IPNS, where Name is
+// the IPNS path of the directory DAG rooted at Root, or issued as a capability
+// token. Token is only filled by the Share method, and is not recorded in the
+// drive.
+type Share struct {
+	ID        string
+	Key       string
+	Root      cid.Cid
+	Name      string
+	Token     string
+	Owner     string
+	CreatedAt string
+	ExpiresAt string
+}
+
+// Upload denotes a pending resumable upload. Parts are sorted by their numbers.
+type Upload struct {
+	ID        string
+	Key       string
+	Parts     []Part
+	CreatedAt string
+}
+
+// Part denotes an uploaded part of an upload.
+type Part struct {
+	Number int
+	Cid    cid.Cid
+	Size   int64
+}
+
 // File denotes the metadata of a file which is stored in a drive instance.
 type File struct {
 	Key       string
@@ -154,6 +602,26 @@ type File struct {
 	Size      int64
 	Timestamp string
 	Owner     string
+	ModTime   string
+	ExpiresAt string
+
+	// Compression is the algorithm the content is compressed with, which is
+	// empty if it is stored as is. Size is the size of the original content,
+	// while StoredSize is the size of the content added to ipfs. StoredSize is
+	// zero for files added before it was recorded.
+	Compression string
+	StoredSize  int64
+
+	// DAG records how the content was chunked and laid out.
+	DAG DAGParams
+
+	// Provenance proves the identity which wrote the file, which is nil for
+	// files written without it.
+	Provenance *Provenance
+
+	// Pins reports the status of the pin of the content, if the drive pins
+	// through a pinning backend. It is only filled by Stat when asked for.
+	Pins []pinning.Status
 }
 
 func (f *File) row(mask uint32) format.Row {
@@ -215,39 +683,82 @@ func Open(ctx context.Context, api coreiface.CoreAPI, resolve string, opts ...*o
 		db.Close()
 		return nil, err
 	}
+	bindAccessController(kv)
 
 	_ = kv.LoadFromSnapshot(ctx)
 	_ = kv.Load(ctx, -1)
 
-	return newDrive(api, db, kv)
+	return newDrive(api, db, kv, opts...)
 }
 
 // Raw creates an instance by directly accepting necessary components.
 func Raw(db iface.OrbitDB, kv iface.KeyValueStore) Instance {
-	return &drive{
-		api: db.IPFS(),
-		db:  db,
-		kv:  kv,
-	}
+	d, _ := newDrive(db.IPFS(), db, kv)
+	return d
 }
 
 func newOrbitDB(ctx context.Context, api coreiface.CoreAPI, opts ...*options.OpenDriveOptions) (iface.OrbitDB, error) {
 	opt := options.MergeOpenDriveOptions(opts...)
-	return orbitdb.NewOrbitDB(ctx, api, &baseorbitdb.NewOrbitDBOptions{
+	dbOpts := &baseorbitdb.NewOrbitDBOptions{
 		Directory: opt.Directory,
 		Logger:    opt.Logger,
-	})
+	}
+
+	// Let orbitdb derive the identity from the key of given name, so that the
+	// same identity can be used across nodes by exporting its key.
+	if opt.Identity != nil {
+		ks, err := keystore.New(keystoreDir(opt))
+		if err != nil {
+			return nil, err
+		}
+		dbOpts.ID = opt.Identity
+		dbOpts.Keystore = ks
+	}
+
+	db, err := orbitdb.NewOrbitDB(ctx, api, dbOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.RegisterAccessControllerType(newPathAccessController); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// keystoreDir returns the directory of the keystore holding identities, which
+// defaults to the one under the orbitdb directory.
+func keystoreDir(opt *options.OpenDriveOptions) string {
+	if opt.Keystore != nil {
+		return *opt.Keystore
+	}
+
+	dir := defaultDirectory
+	if opt.Directory != nil {
+		dir = *opt.Directory
+	}
+	return filepath.Join(dir, "keystore")
 }
 
 func openKeyValueStore(ctx context.Context, db orbitdb.OrbitDB, dbAddr string, opts ...*options.OpenDriveOptions) (iface.KeyValueStore, error) {
 	opt := options.MergeOpenDriveOptions(opts...)
+
+	params := opt.AccessController
+	if params == nil && isSet(opt.PathScoped) {
+		params = accesscontroller.NewSimpleManifestParams(PathAccessControllerType, map[string][]string{
+			PermissionAdmin.String(): {db.Identity().ID},
+		})
+	}
+
 	store, err := db.Open(ctx, dbAddr, &iface.CreateDBOptions{
 		Directory:        opt.Directory,
 		Overwrite:        boolPtr(false),
 		LocalOnly:        boolPtr(false),
 		Create:           opt.Create,
 		StoreType:        strPtr(keyvalueStoreType),
-		AccessController: opt.AccessController,
+		AccessController: params,
 		Replicate:        boolPtr(true),
 	})
 	if err != nil {
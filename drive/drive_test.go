@@ -3,18 +3,43 @@ package drive
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/identityprovider"
+	logiface "berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
 	ipfsCore "github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/coreapi"
 	mock "github.com/ipfs/go-ipfs/core/mock"
 	iface "github.com/ipfs/interface-go-ipfs-core"
+	coreopts "github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	clusterclient "github.com/ipfs/ipfs-cluster/api/rest/client"
+	"github.com/libp2p/go-libp2p-core/peer"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/meowdada/ipfstor/cluster"
+	"github.com/meowdada/ipfstor/keystore"
 	"github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/compress"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,16 +81,16 @@ func mockTempDir(t *testing.T, name string) (string, func()) {
 	return path, cleanup
 }
 
-func mockDrive(t *testing.T, resolve string) (Instance, func()) {
+func mockDrive(t *testing.T, resolve string, extra ...*options.OpenDriveOptions) (Instance, func()) {
 	ctx := context.Background()
 	_, dbPathClean := mockTempDir(t, "db")
 	net := mockNet(ctx)
 	node, nodeClean := mockIPFSNode(ctx, t, net)
 	ipfs := mockAPI(t, node)
 
-	opts := options.OpenDrive().SetCreate(true)
+	opts := append([]*options.OpenDriveOptions{options.OpenDrive().SetCreate(true)}, extra...)
 
-	d, err := Open(ctx, ipfs, resolve, opts)
+	d, err := Open(ctx, ipfs, resolve, opts...)
 	require.NoError(t, err)
 
 	return d, func() {
@@ -75,6 +100,25 @@ func mockDrive(t *testing.T, resolve string) (Instance, func()) {
 	}
 }
 
+// mockPeer opens the drive with another identity on the ipfs node of the drive.
+// The peer shares the access controller of the drive, while the entries of the
+// drive are replicated to it asynchronously.
+func mockPeer(t *testing.T, d Instance, identity string) (Instance, func()) {
+	t.Helper()
+
+	ctx := context.Background()
+	dir, dirClean := mockTempDir(t, "peer")
+
+	opts := options.OpenDrive().SetDirectory(dir).SetIdentity(identity).SetCreate(true)
+	p, err := Open(ctx, d.(*drive).api, d.Address(), opts)
+	require.NoError(t, err)
+
+	return p, func() {
+		p.Close(ctx)
+		dirClean()
+	}
+}
+
 func mockFile(t *testing.T, key string, content []byte) func() {
 	f, err := os.Create(key)
 	require.NoError(t, err)
@@ -88,6 +132,88 @@ func mockFile(t *testing.T, key string, content []byte) func() {
 	}
 }
 
+// mockClusterAPI serves the subset of the REST API of ipfs-cluster used by
+// drives, with a single peer pinning everything it is asked to.
+type mockClusterAPI struct {
+	mu   sync.Mutex
+	pins map[string]url.Values
+}
+
+const mockClusterPeer = "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+
+func mockCluster(t *testing.T) (clusterclient.Client, *mockClusterAPI, func()) {
+	t.Helper()
+
+	m := &mockClusterAPI{pins: make(map[string]url.Values)}
+	srv := httptest.NewServer(m)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c, err := cluster.New(context.Background(), "/ip4/127.0.0.1/tcp/"+u.Port())
+	require.NoError(t, err)
+
+	return c, m, srv.Close
+}
+
+func (m *mockClusterAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/pins/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, err := cid.Decode(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ref := map[string]string{"/": c.String()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resp interface{}
+	switch r.Method {
+	case http.MethodPost:
+		m.pins[c.String()] = r.URL.Query()
+		resp = map[string]interface{}{"cid": ref}
+	case http.MethodDelete:
+		delete(m.pins, c.String())
+		resp = map[string]interface{}{"cid": ref}
+	case http.MethodGet:
+		status := "unpinned"
+		if _, ok := m.pins[c.String()]; ok {
+			status = "pinned"
+		}
+		resp = map[string]interface{}{
+			"cid": ref,
+			"peer_map": map[string]interface{}{
+				mockClusterPeer: map[string]interface{}{
+					"cid":       ref,
+					"peername":  "peer0",
+					"status":    status,
+					"timestamp": time.Now(),
+					"error":     "",
+				},
+			},
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockClusterAPI) pinned(c cid.Cid) (url.Values, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.pins[c.String()]
+	return q, ok
+}
+
 func TestOpenDrive(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -227,6 +353,26 @@ func TestDriveIdentity(t *testing.T) {
 	}()
 }
 
+func TestDriveKeystoreIdentity(t *testing.T) {
+	dir, dirClean := mockTempDir(t, "keystore")
+	defer dirClean()
+
+	ks, err := keystore.New(dir)
+	require.NoError(t, err)
+
+	id, err := ks.Create("service")
+	require.NoError(t, err)
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetKeystore(dir).SetIdentity("service"))
+	defer cleanup()
+	require.Equal(t, id.ID, d.Identity())
+
+	// The signing key derived by orbitdb is not listed as an identity.
+	ids, err := ks.List()
+	require.NoError(t, err)
+	require.Equal(t, []keystore.Identity{id}, ids)
+}
+
 func TestDriveAddFile(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -449,16 +595,1423 @@ func TestDriveStat(t *testing.T) {
 	})
 }
 
-func TestDriveList(t *testing.T) {
+func TestDrivePutIf(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("Put an absent key", func(t *testing.T) {
+		d, cleanup := mockDrive(t, mockDriveName)
+		defer cleanup()
+
+		_, err := d.PutIf(ctx, "abc", bytes.NewBufferString("123"), cid.Undef)
+		require.NoError(t, err)
+
+		_, err = d.PutIf(ctx, "abc", bytes.NewBufferString("456"), cid.Undef)
+		require.True(t, errors.Is(err, ErrPreconditionFailed))
+	})
+
+	t.Run("Put with matched and mismatched cid", func(t *testing.T) {
+		d, cleanup := mockDrive(t, mockDriveName)
+		defer cleanup()
+
+		f, err := d.Add(ctx, "abc", bytes.NewBufferString("123"))
+		require.NoError(t, err)
+
+		g, err := d.PutIf(ctx, "abc", bytes.NewBufferString("456"), f.Cid)
+		require.NoError(t, err)
+
+		_, err = d.PutIf(ctx, "abc", bytes.NewBufferString("789"), f.Cid)
+		var perr *PreconditionError
+		require.True(t, errors.As(err, &perr))
+		require.Equal(t, g.Cid, perr.Actual)
+	})
+
+	t.Run("Lose a race to a concurrent write", func(t *testing.T) {
+		d, cleanup := mockDrive(t, mockDriveName)
+		defer cleanup()
+
+		// Write the key while the content of the conditional write is read.
+		var (
+			once    sync.Once
+			raceErr error
+		)
+		race := func(int64) {
+			once.Do(func() {
+				_, raceErr = d.Add(ctx, "abc", bytes.NewBufferString("winner"))
+			})
+		}
+
+		opt := options.Add().SetIfNotExists(true).SetProgress(race)
+		_, err := d.Add(ctx, "abc", bytes.NewBufferString("loser"), opt)
+		require.NoError(t, raceErr)
+		require.True(t, errors.Is(err, ErrPreconditionFailed))
+
+		// The content of the rejected file is not left pinned.
+		node, err := d.(*drive).api.Unixfs().Add(ctx, files.NewBytesFile([]byte("loser")), coreopts.Unixfs.HashOnly(true))
+		require.NoError(t, err)
+		_, pinned, err := d.(*drive).api.Pin().IsPinned(ctx, node)
+		require.NoError(t, err)
+		require.False(t, pinned)
+	})
+
+	t.Run("Remove with mismatched cid", func(t *testing.T) {
+		d, cleanup := mockDrive(t, mockDriveName)
+		defer cleanup()
+
+		f, err := d.Add(ctx, "abc", bytes.NewBufferString("123"))
+		require.NoError(t, err)
+
+		err = d.Remove(ctx, "abc", options.Remove().SetIfMatch(cid.Undef))
+		require.True(t, errors.Is(err, ErrPreconditionFailed))
 
+		err = d.Remove(ctx, "abc", options.Remove().SetIfMatch(f.Cid))
+		require.NoError(t, err)
+	})
 }
 
-func TestDriveRemove(t *testing.T) {
+func TestDriveBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	_, err := d.Add(ctx, "existing", bytes.NewBufferString("0"))
+	require.NoError(t, err)
 
+	content := strings.Repeat("2020-01-01 INFO request served\n", 100)
+	items := []BatchItem{
+		{Key: "a", Reader: bytes.NewBufferString("1")},
+		{Key: "b", Reader: bytes.NewBufferString("22")},
+		{Key: "", Reader: bytes.NewBufferString("333")},
+		{Key: "existing", Reader: bytes.NewBufferString("4444"), Options: options.Add().SetIfNotExists(true)},
+		{Key: "log", Reader: bytes.NewBufferString(content), Options: options.Add().SetCompression(compress.Gzip)},
+	}
+
+	results, err := d.AddBatch(ctx, items, options.Batch().SetWorkers(2))
+	var berr *BatchError
+	require.True(t, errors.As(err, &berr))
+	require.Equal(t, 2, berr.Failed)
+	require.Len(t, results, len(items))
+	require.NoError(t, results[0].Err)
+	require.Equal(t, int64(2), results[1].File.Size)
+	require.Equal(t, ErrEmptyKey, results[2].Err)
+	require.True(t, errors.Is(results[3].Err, ErrPreconditionFailed))
+	require.NoError(t, results[4].Err)
+	require.Equal(t, compress.Gzip, results[4].File.Compression)
+
+	// Content shared with another key stays pinned once the batch is removed.
+	shared, err := d.Add(ctx, "c", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	results, err = d.RemoveBatch(ctx, []RemoveItem{
+		{Key: "a"},
+		{Key: "b"},
+		{Key: "c", Options: options.Remove().SetIfMatch(cid.Undef)},
+	})
+	require.True(t, errors.As(err, &berr))
+	require.Equal(t, 1, berr.Failed)
+	require.Len(t, results, 3)
+	require.True(t, errors.Is(results[2].Err, ErrPreconditionFailed))
+
+	_, err = d.Stat(ctx, "a")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	_, pinned, err := d.(*drive).api.Pin().IsPinned(ctx, path.IpfsPath(shared.Cid))
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	_, pinned, err = d.(*drive).api.Pin().IsPinned(ctx, path.IpfsPath(results[1].File.Cid))
+	require.NoError(t, err)
+	require.False(t, pinned)
 }
 
-func TestDriveGrant(t *testing.T) {
+func TestDriveDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	src, srcClean := mockTempDir(t, "src")
+	defer srcClean()
+
+	dst, dstClean := mockTempDir(t, "dst")
+	defer dstClean()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("b"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "c.tmp"), []byte("c"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "a.txt"), mtime, mtime))
+
+	added, err := d.AddDir(ctx, "data", src, options.AddDir().SetIgnore("*.tmp"))
+	require.NoError(t, err)
+	require.Len(t, added, 2)
+
+	_, err = d.Stat(ctx, "data/sub/b.txt")
+	require.NoError(t, err)
+
+	// Keys merely starting with the prefix are not fetched.
+	_, err = d.Add(ctx, "data2/a.txt", bytes.NewBufferString("other"))
+	require.NoError(t, err)
+
+	fetched, err := d.GetDir(ctx, "data", dst)
+	require.NoError(t, err)
+	require.Len(t, fetched, 2)
+
+	_, err = os.Stat(filepath.Join(dst, "2"))
+	require.True(t, os.IsNotExist(err))
+
+	info, err := os.Stat(filepath.Join(dst, "a.txt"))
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(mtime))
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), content)
+}
+
+func TestDriveArchive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, format := range []ArchiveFormat{ArchiveTar, ArchiveZip} {
+		src, srcCleanup := mockDrive(t, mockDriveName)
+		defer srcCleanup()
+
+		_, err := src.Add(ctx, "data/a", bytes.NewBufferString("1"))
+		require.NoError(t, err)
+		_, err = src.Add(ctx, "data/sub/b", bytes.NewBufferString("22"))
+		require.NoError(t, err)
+		_, err = src.Add(ctx, "other", bytes.NewBufferString("333"))
+		require.NoError(t, err)
+		_, err = src.Add(ctx, "data2/c", bytes.NewBufferString("4444"))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, src.ExportArchive(ctx, "data", &buf, format))
+
+		dst, dstCleanup := mockDrive(t, mockDriveName)
+		defer dstCleanup()
+
+		added, err := dst.ImportArchive(ctx, "imported", &buf)
+		require.NoError(t, err)
+		require.Len(t, added, 2)
+
+		rc, err := dst.Get(ctx, "imported/sub/b")
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, []byte("22"), content)
+	}
+}
+
+func TestDriveCAR(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, srcCleanup := mockDrive(t, mockDriveName)
+	defer srcCleanup()
+
+	f, err := src.Add(ctx, "abc", bytes.NewBufferString("123"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, src.ExportCAR(ctx, &buf))
+
+	_, dbPathClean := mockTempDir(t, "db")
+	defer dbPathClean()
+	net := mockNet(ctx)
+	node, nodeClean := mockIPFSNode(ctx, t, net)
+	defer nodeClean()
+	ipfs := mockAPI(t, node)
+
+	dst, err := ImportCAR(ctx, ipfs, &buf, "imported")
+	require.NoError(t, err)
+	defer dst.Close(ctx)
+
+	g, err := dst.Stat(ctx, "abc")
+	require.NoError(t, err)
+	require.True(t, f.Cid.Equals(g.Cid))
+
+	rc, err := dst.Get(ctx, "abc")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, []byte("123"), content)
+}
+
+func TestDriveExpireScoped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetPathScoped(true))
+	defer cleanup()
+
+	peer, peerClean := mockPeer(t, d, "peer")
+	defer peerClean()
+	require.NoError(t, d.GrantPrefix(ctx, peer.Identity(), "teamB/", PermissionWrite))
+
+	past := options.Add().SetExpiresAt(time.Now().Add(-time.Minute))
+	_, err := d.Add(ctx, "teamA/a", bytes.NewBufferString("1"), past)
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "teamB/b", bytes.NewBufferString("2"), past)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, errA := peer.Stat(ctx, "teamA/a")
+		_, errB := peer.Stat(ctx, "teamB/b")
+		return errA == nil && errB == nil && peer.(*drive).checkWrite("teamB/b") == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// A file the peer cannot write does not block the expiry of the others.
+	expired, err := peer.Expire(ctx)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, "teamB/b", expired[0].Key)
+
+	_, err = peer.Stat(ctx, "teamA/a")
+	require.NoError(t, err)
+}
+
+func TestDriveSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	f, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	c, err := d.Snapshot(ctx, "v1")
+	require.NoError(t, err)
+
+	_, err = d.Snapshot(ctx, "v1")
+	require.Equal(t, ErrSnapshotExists, err)
+
+	_, err = d.Add(ctx, "a", bytes.NewBufferString("2"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "b", bytes.NewBufferString("3"))
+	require.NoError(t, err)
+
+	snapshots, err := d.ListSnapshots(ctx)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.True(t, c.Equals(snapshots[0].Cid))
+
+	lr, err := d.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, lr.Files(), 2)
+
+	s, err := d.OpenSnapshot(ctx, "v1")
+	require.NoError(t, err)
+	defer s.Close(ctx)
+
+	g, err := s.Stat(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, f.Cid.Equals(g.Cid))
+
+	_, err = s.Add(ctx, "c", bytes.NewBufferString("4"))
+	require.Equal(t, ErrReadOnly, err)
+
+	require.NoError(t, d.RollbackTo(ctx, "v1"))
+
+	g, err = d.Stat(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, f.Cid.Equals(g.Cid))
+
+	_, err = d.Stat(ctx, "b")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	require.True(t, strings.HasSuffix(snapshots[0].Timestamp, "UTC"))
+}
+
+func TestDriveRollbackTrash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetTrash(true))
+	defer cleanup()
+
+	_, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	_, err = d.Snapshot(ctx, "v1")
+	require.NoError(t, err)
+
+	b, err := d.Add(ctx, "b", bytes.NewBufferString("2"))
+	require.NoError(t, err)
+
+	// Files added after the snapshot are moved to the trash bin.
+	require.NoError(t, d.RollbackTo(ctx, "v1"))
+	_, err = d.Stat(ctx, "b")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	entries, err := d.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, b.Cid, entries[0].File.Cid)
+
+	_, pinned, err := d.(*drive).api.Pin().IsPinned(ctx, path.IpfsPath(b.Cid))
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	// A peer without permission cannot roll back the drive.
+	_, err = d.Add(ctx, "c", bytes.NewBufferString("3"))
+	require.NoError(t, err)
+	peer, peerClean := mockPeer(t, d, "peer")
+	defer peerClean()
+	require.Eventually(t, func() bool {
+		_, err := peer.Stat(ctx, "c")
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	err = peer.RollbackTo(ctx, "v1")
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	_, err = d.Stat(ctx, "c")
+	require.NoError(t, err)
+}
+
+func TestDriveDiff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	_, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "b", bytes.NewBufferString("2"))
+	require.NoError(t, err)
+
+	_, err = d.Snapshot(ctx, "v1")
+	require.NoError(t, err)
+
+	_, err = d.Add(ctx, "a", bytes.NewBufferString("3"))
+	require.NoError(t, err)
+	require.NoError(t, d.Remove(ctx, "b"))
+	_, err = d.Add(ctx, "c", bytes.NewBufferString("4"))
+	require.NoError(t, err)
+
+	s, err := d.OpenSnapshot(ctx, "v1")
+	require.NoError(t, err)
+
+	dr, err := Diff(ctx, s, d, "")
+	require.NoError(t, err)
+
+	changes := dr.Changes()
+	require.Len(t, changes, 3)
+	require.Equal(t, ChangeModified, changes[0].Type)
+	require.Equal(t, ChangeRemoved, changes[1].Type)
+	require.Equal(t, ChangeAdded, changes[2].Type)
+	require.NotEmpty(t, dr.Bytes())
+
+	// Compare a local directory against files within the directory of the
+	// prefix, including one stitched from uploaded parts.
+	dir, dirClean := mockTempDir(t, "diff")
+	defer dirClean()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("1"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "large"), []byte("hello world"), 0644))
+
+	_, err = d.Add(ctx, "docs/a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "docs2/a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	u, err := d.BeginUpload(ctx, "docs/large")
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("hello "))
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 2, bytes.NewBufferString("world"))
+	require.NoError(t, err)
+	f, err := d.CompleteUpload(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, f.DAG.Parts)
+
+	dr, err = d.DiffDir(ctx, "docs", dir)
+	require.NoError(t, err)
+	for _, c := range dr.Changes() {
+		require.Equal(t, ChangeMetadata, c.Type, c.Key)
+	}
+}
+
+func TestDriveTrash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetTrash(true))
+	defer cleanup()
+
+	f, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "b", bytes.NewBufferString("2"))
+	require.NoError(t, err)
+
+	require.NoError(t, d.Remove(ctx, "a"))
+	require.NoError(t, d.Remove(ctx, "b"))
+
+	_, err = d.Stat(ctx, "a")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	entries, err := d.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
 
+	g, err := d.Restore(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, f.Cid.Equals(g.Cid))
+
+	rc, err := d.Get(ctx, "a")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), content)
+
+	purged, err := d.PurgeTrash(ctx, time.Hour)
+	require.NoError(t, err)
+	require.Empty(t, purged)
+
+	purged, err = d.PurgeTrash(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, "b", purged[0].File.Key)
+
+	entries, err = d.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Removing a missing key leaves the trash bin intact.
+	require.Equal(t, ErrNoSuchKey, d.Remove(ctx, "missing"))
+	entries, err = d.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Content shared with a live file stays pinned once the trash is purged,
+	// or once the trashed version is replaced.
+	shared, err := d.Add(ctx, "c", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	require.True(t, f.Cid.Equals(shared.Cid))
+
+	require.NoError(t, d.Remove(ctx, "a"))
+	latest, err := d.Add(ctx, "a", bytes.NewBufferString("3"))
+	require.NoError(t, err)
+	require.NoError(t, d.Remove(ctx, "a"))
+
+	// Every removed version stays in the trash bin, and the latest one is
+	// restored first.
+	entries, err = d.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.True(t, f.Cid.Equals(entries[0].File.Cid))
+	require.True(t, latest.Cid.Equals(entries[1].File.Cid))
+
+	g, err = d.Restore(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, latest.Cid.Equals(g.Cid))
+	require.NoError(t, d.Remove(ctx, "a"))
+
+	purged, err = d.PurgeTrash(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, purged, 2)
+
+	_, pinned, err := d.(*drive).api.Pin().IsPinned(ctx, path.IpfsPath(shared.Cid))
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	_, pinned, err = d.(*drive).api.Pin().IsPinned(ctx, path.IpfsPath(latest.Cid))
+	require.NoError(t, err)
+	require.False(t, pinned)
+}
+
+func TestDriveExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	_, err := d.Add(ctx, "tmp", bytes.NewBufferString("1"), options.Add().SetExpiresAt(time.Now().Add(-time.Minute)))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "keep", bytes.NewBufferString("2"), options.Add().SetTTL(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, d.SetLifecycleRule(ctx, LifecycleRule{Prefix: "logs/", ExpireAfter: time.Hour}))
+	rules, err := d.ListLifecycleRules(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []LifecycleRule{{Prefix: "logs/", ExpireAfter: time.Hour}}, rules)
+
+	expired, err := d.Expire(ctx)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	require.Equal(t, "tmp", expired[0].Key)
+
+	_, err = d.Stat(ctx, "tmp")
+	require.Equal(t, ErrNoSuchKey, err)
+	_, err = d.Stat(ctx, "keep")
+	require.NoError(t, err)
+
+	// Only admins may manage the rules, which can expire any file.
+	peer, peerClean := mockPeer(t, d, "peer")
+	defer peerClean()
+	err = peer.SetLifecycleRule(ctx, LifecycleRule{Prefix: "", ExpireAfter: time.Second})
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.True(t, errors.Is(peer.RemoveLifecycleRule(ctx, "logs/"), ErrPermissionDenied))
+
+	require.NoError(t, d.RemoveLifecycleRule(ctx, "logs/"))
+	rules, err = d.ListLifecycleRules(ctx)
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	// Timestamps are recorded in UTC, so that they parse the same on every peer.
+	f, err := d.Stat(ctx, "keep")
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(f.Timestamp, "UTC"))
+	require.WithinDuration(t, time.Now(), addedAt(f), time.Minute)
+
+	now := time.Now().UTC()
+	at := func(ago time.Duration) string {
+		return now.Add(-ago).Format(time.RFC1123)
+	}
+	files := []File{
+		{Key: "logs/1", Timestamp: at(3 * time.Hour)},
+		{Key: "logs/2", Timestamp: at(2 * time.Hour)},
+		{Key: "logs/3", Timestamp: at(time.Hour)},
+		{Key: "build/1", Timestamp: at(48 * time.Hour)},
+		{Key: "build/2", Timestamp: at(time.Minute)},
+	}
+	rules = []LifecycleRule{
+		{Prefix: "logs/", KeepLast: 2},
+		{Prefix: "build/", ExpireAfter: 24 * time.Hour},
+	}
+
+	var keys []string
+	for _, f := range expiredFiles(files, rules, now) {
+		keys = append(keys, f.Key)
+	}
+	require.Equal(t, []string{"build/1", "logs/1"}, keys)
+}
+
+func TestDriveQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetQuota(options.Quota{Bytes: 10, Files: 2}))
+	defer cleanup()
+
+	_, err := d.Add(ctx, "a", bytes.NewBufferString("12345"))
+	require.NoError(t, err)
+
+	_, err = d.Add(ctx, "b", bytes.NewBufferString("123456"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+	var qerr *QuotaError
+	require.True(t, errors.As(err, &qerr))
+	require.Equal(t, int64(11), qerr.Usage.Bytes)
+
+	_, err = d.Stat(ctx, "b")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	// Replacing a file only accounts the difference.
+	_, err = d.Add(ctx, "a", bytes.NewBufferString("1234567890"))
+	require.NoError(t, err)
+
+	require.NoError(t, d.Remove(ctx, "a"))
+	_, err = d.Add(ctx, "b", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "c", bytes.NewBufferString("2"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "d", bytes.NewBufferString("3"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	report, err := d.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Usage{Bytes: 2, Files: 2}, report.Total)
+	require.Equal(t, Usage{Bytes: 2, Files: 2}, report.Owners[d.Identity()])
+	require.Equal(t, options.Quota{Bytes: 10, Files: 2}, report.Quota)
+
+	// Parts are rejected once the upload exceeds the quota.
+	u, err := d.BeginUpload(ctx, "b")
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("12345"))
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 2, bytes.NewBufferString("123456"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	uploads, err := d.ListUploads(ctx)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Len(t, uploads[0].Parts, 1)
+	require.NoError(t, d.AbortUpload(ctx, u.ID))
+}
+
+func TestDriveStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	_, err := d.Add(ctx, "a/1", bytes.NewBufferString("1234"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "a/2", bytes.NewBufferString("1234"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "b/c/3", bytes.NewBufferString("123456"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "4", bytes.NewBufferString("12"))
+	require.NoError(t, err)
+
+	s, err := d.Stats(ctx, options.Stats().SetLargest(2))
+	require.NoError(t, err)
+	require.Equal(t, Usage{Bytes: 16, Files: 4}, s.Total)
+	require.Equal(t, Usage{Bytes: 12, Files: 3}, s.Unique)
+	require.Equal(t, map[string]Usage{d.Identity(): s.Total}, s.Owners)
+	require.Equal(t, map[string]Usage{
+		"a/": {Bytes: 8, Files: 2},
+		"b/": {Bytes: 6, Files: 1},
+		"":   {Bytes: 2, Files: 1},
+	}, s.Prefixes)
+	require.Len(t, s.Largest, 2)
+	require.Equal(t, "b/c/3", s.Largest[0].Key)
+	require.Equal(t, "a/1", s.Largest[1].Key)
+	require.NotEmpty(t, s.Bytes())
+
+	s, err = d.Stats(ctx, options.Stats().SetPrefixDepth(2))
+	require.NoError(t, err)
+	require.Contains(t, s.Prefixes, "b/c/")
+}
+
+func TestGC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbPath, dbPathClean := mockTempDir(t, "db")
+	defer dbPathClean()
+	node, nodeClean := mockIPFSNode(ctx, t, mockNet(ctx))
+	defer nodeClean()
+	ipfs := mockAPI(t, node)
+
+	d, err := Open(ctx, ipfs, mockDriveName, options.OpenDrive().SetDirectory(dbPath).SetCreate(true))
+	require.NoError(t, err)
+	defer d.Close(ctx)
+
+	f, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	// Content pinned by the drive but no longer referenced.
+	orphan, err := ipfs.Unixfs().Add(ctx, files.NewBytesFile([]byte("2")), coreopts.Unixfs.Pin(false))
+	require.NoError(t, err)
+	require.NoError(t, d.(*drive).pin(ctx, orphan.Cid(), "orphan"))
+
+	// Content pinned by another application.
+	foreign, err := ipfs.Unixfs().Add(ctx, files.NewBytesFile([]byte("3")), coreopts.Unixfs.Pin(true))
+	require.NoError(t, err)
+
+	orphans, err := GC(ctx, ipfs, []Instance{d}, true)
+	require.NoError(t, err)
+	require.Contains(t, orphans, orphan.Cid())
+	require.NotContains(t, orphans, f.Cid)
+	require.NotContains(t, orphans, foreign.Cid())
+
+	_, pinned, err := ipfs.Pin().IsPinned(ctx, orphan)
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	collected := false
+	orphans, err = GC(ctx, ipfs, []Instance{d}, false, options.GC().SetRepoGC(func(ctx context.Context) error {
+		collected = true
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Contains(t, orphans, orphan.Cid())
+	require.True(t, collected)
+
+	_, pinned, err = ipfs.Pin().IsPinned(ctx, orphan)
+	require.NoError(t, err)
+	require.False(t, pinned)
+
+	_, pinned, err = ipfs.Pin().IsPinned(ctx, path.IpfsPath(f.Cid))
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	_, pinned, err = ipfs.Pin().IsPinned(ctx, foreign)
+	require.NoError(t, err)
+	require.True(t, pinned)
+}
+
+func TestDriveCluster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, m, clusterClean := mockCluster(t)
+	defer clusterClean()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetCluster(c).SetReplication(2, 3))
+	defer cleanup()
+
+	f, err := d.Add(ctx, "a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	q, ok := m.pinned(f.Cid)
+	require.True(t, ok)
+	require.Equal(t, "2", q.Get("replication-min"))
+	require.Equal(t, "3", q.Get("replication-max"))
+	require.Equal(t, "a", q.Get("name"))
+
+	g, err := d.Stat(ctx, "a")
+	require.NoError(t, err)
+	require.Empty(t, g.Pins)
+
+	g, err = d.Stat(ctx, "a", options.Stat().SetPins(true))
+	require.NoError(t, err)
+	require.Len(t, g.Pins, 1)
+	require.Equal(t, mockClusterPeer, g.Pins[0].Peer)
+	require.Equal(t, "peer0", g.Pins[0].PeerName)
+	require.Equal(t, "pinned", g.Pins[0].Status)
+
+	require.NoError(t, d.Remove(ctx, "a"))
+	_, ok = m.pinned(f.Cid)
+	require.False(t, ok)
+}
+
+func TestDrivePathScoped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetPathScoped(true))
+	defer cleanup()
+
+	_, err := d.Add(ctx, "teamB/a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+
+	require.Error(t, d.GrantPrefix(ctx, "teamA", "teamA", PermissionWrite))
+	require.Error(t, d.GrantPrefix(ctx, "teamA", "teamA/", PermissionAdmin))
+	require.NoError(t, d.GrantPrefix(ctx, "teamA", "teamA/", PermissionWrite))
+
+	grants, err := d.ListGrants(ctx)
+	require.NoError(t, err)
+	var scopes []Scope
+	for _, g := range grants {
+		if g.Identity == "teamA" {
+			scopes = g.Scopes
+		}
+	}
+	require.Equal(t, []Scope{{Prefix: "teamA/", Permission: PermissionWrite}}, scopes)
+
+	ac := d.(*drive).kv.AccessController().(*pathAccessController)
+	require.NoError(t, ac.authorize(ctx, "teamA", "teamA/x/y"))
+	require.NoError(t, ac.authorize(ctx, "teamA", trashPrefix+"teamA/x"))
+	require.True(t, errors.Is(ac.authorize(ctx, "teamA", "teamB/a"), ErrPermissionDenied))
+	require.True(t, errors.Is(ac.authorize(ctx, "teamA", aclPrefix+"teamA/write/"), ErrPermissionDenied))
+	require.True(t, errors.Is(ac.authorize(ctx, "teamA", lifecyclePrefix+"teamA/"), ErrPermissionDenied))
+
+	require.NoError(t, d.RevokePrefix(ctx, "teamA", "teamA/"))
+	require.True(t, errors.Is(ac.authorize(ctx, "teamA", "teamA/x/y"), ErrPermissionDenied))
+
+	require.Equal(t, []string{"", "a/", "a/b/"}, keyPrefixes("a/b/c"))
+}
+
+// mockEntry is a log entry replicated from a peer, carrying only what the access
+// controller looks at.
+type mockEntry struct {
+	logiface.IPFSLogEntry
+	payload  []byte
+	identity *identityprovider.Identity
+	hash     cid.Cid
+	clock    logiface.IPFSLogLamportClock
+}
+
+func (e *mockEntry) GetPayload() []byte                      { return e.payload }
+func (e *mockEntry) GetIdentity() *identityprovider.Identity { return e.identity }
+func (e *mockEntry) GetHash() cid.Cid                        { return e.hash }
+func (e *mockEntry) GetClock() logiface.IPFSLogLamportClock  { return e.clock }
+
+func mockLogEntry(t *testing.T, identity string, time int, op, key string) *mockEntry {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{"op": op, "key": key, "value": []byte("1")})
+	require.NoError(t, err)
+
+	sum, err := mh.Sum(append(payload, []byte(fmt.Sprintf("%s/%d", identity, time))...), mh.SHA2_256, -1)
+	require.NoError(t, err)
+
+	return &mockEntry{
+		payload:  payload,
+		identity: &identityprovider.Identity{ID: identity},
+		hash:     cid.NewCidV1(cid.Raw, sum),
+		clock:    entry.NewLamportClock([]byte(identity), time),
+	}
+}
+
+// mockLog is the log being joined.
+type mockLog []accesscontroller.LogEntry
+
+func (l mockLog) GetLogEntries() []accesscontroller.LogEntry { return l }
+
+// mockIdentityProvider accepts every identity.
+type mockIdentityProvider struct {
+	identityprovider.Interface
+}
+
+func (mockIdentityProvider) VerifyIdentity(*identityprovider.Identity) error { return nil }
+
+func TestDrivePathScopedReplicated(t *testing.T) {
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetPathScoped(true))
+	defer cleanup()
+
+	ac := d.(*drive).kv.AccessController().(*pathAccessController)
+	p := mockIdentityProvider{}
+
+	rule := aclKey("teamA", PermissionWrite, "teamA/")
+	grant := mockLogEntry(t, d.Identity(), 1, "PUT", rule)
+	write := mockLogEntry(t, "teamA", 2, "PUT", "teamA/x")
+	revoke := mockLogEntry(t, d.Identity(), 3, "DEL", rule)
+	late := mockLogEntry(t, "teamA", 4, "PUT", "teamA/y")
+	log := mockLog{late, revoke, write, grant}
+
+	// A write replicated along with the grant permitting it is accepted, and it
+	// stays valid for peers replicating it after the revoke.
+	require.NoError(t, ac.CanAppend(write, p, log))
+
+	// Writes ordered after the revoke, or without any grant, are rejected.
+	require.True(t, errors.Is(ac.CanAppend(late, p, log), ErrPermissionDenied))
+	require.True(t, errors.Is(ac.CanAppend(write, p, mockLog{write}), ErrPermissionDenied))
+
+	// Identities other than admins cannot grant themselves.
+	forged := mockLogEntry(t, "teamA", 1, "PUT", aclKey("teamA", PermissionAdmin, ""))
+	require.True(t, errors.Is(ac.CanAppend(forged, p, mockLog{forged, write}), ErrPermissionDenied))
+}
+
+func TestDrivePathScopedReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	net := mockNet(ctx)
+	var (
+		apis  []iface.CoreAPI
+		nodes []*ipfsCore.IpfsNode
+	)
+	for i := 0; i < 3; i++ {
+		node, nodeClean := mockIPFSNode(ctx, t, net)
+		defer nodeClean()
+		nodes = append(nodes, node)
+		apis = append(apis, mockAPI(t, node))
+	}
+	for _, node := range nodes[1:] {
+		_, err := net.LinkPeers(nodes[0].Identity, node.Identity)
+		require.NoError(t, err)
+	}
+
+	open := func(i int, address string, opts ...*options.OpenDriveOptions) Instance {
+		dir, dirClean := mockTempDir(t, "db")
+		t.Cleanup(dirClean)
+
+		if i != 0 {
+			info := peer.AddrInfo{ID: nodes[0].Identity, Addrs: nodes[0].PeerHost.Addrs()}
+			require.NoError(t, apis[i].Swarm().Connect(ctx, info))
+		}
+
+		opts = append(opts, options.OpenDrive().SetDirectory(dir).SetCreate(true))
+		d, err := Open(ctx, apis[i], address, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { d.Close(ctx) })
+		return d
+	}
+
+	eventually := func(condition func() bool) {
+		require.Eventually(t, condition, 30*time.Second, 100*time.Millisecond)
+	}
+	present := func(d Instance, key string) func() bool {
+		return func() bool {
+			_, err := d.Stat(ctx, key)
+			return err == nil
+		}
+	}
+
+	admin := open(0, "replication", options.OpenDrive().SetPathScoped(true))
+	writer := open(1, admin.Address())
+
+	// The writer may write under its prefix once the grant is replicated, and
+	// its writes are accepted by the admin.
+	require.NoError(t, admin.GrantPrefix(ctx, writer.Identity(), "teamB/", PermissionWrite))
+	eventually(func() bool {
+		return writer.(*drive).checkWrite("teamB/a") == nil
+	})
+
+	_, err := writer.Add(ctx, "teamB/a", bytes.NewBufferString("1"))
+	require.NoError(t, err)
+	eventually(present(admin, "teamB/a"))
+
+	_, err = writer.Add(ctx, "teamA/a", bytes.NewBufferString("1"))
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+
+	// Writes made before the revoke are accepted by a peer joining later.
+	require.NoError(t, admin.RevokePrefix(ctx, writer.Identity(), "teamB/"))
+	late := open(2, admin.Address())
+	eventually(present(late, "teamB/a"))
+
+	eventually(func() bool {
+		return errors.Is(writer.(*drive).checkWrite("teamB/b"), ErrPermissionDenied)
+	})
+}
+
+func TestDriveProvenance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	f, err := d.Add(ctx, "signed", bytes.NewBufferString("content"))
+	require.NoError(t, err)
+	require.NotNil(t, f.Provenance)
+
+	verified, err := d.VerifyProvenance(ctx, "signed")
+	require.NoError(t, err)
+	require.Equal(t, f.Cid, verified.Cid)
+
+	// Alter the metadata behind the drive.
+	kv := d.(*drive).kv
+	f.Size++
+	_, err = kv.Put(ctx, f.Key, mustEncodeGob(f))
+	require.NoError(t, err)
+	_, err = d.VerifyProvenance(ctx, "signed")
+	require.True(t, errors.Is(err, ErrInvalidProvenance))
+
+	f.Size--
+	f.Owner = "0123"
+	_, err = kv.Put(ctx, f.Key, mustEncodeGob(f))
+	require.NoError(t, err)
+	_, err = d.VerifyProvenance(ctx, "signed")
+	require.True(t, errors.Is(err, ErrInvalidProvenance))
+
+	f.Owner = d.Identity()
+	f.Provenance = nil
+	_, err = kv.Put(ctx, f.Key, mustEncodeGob(f))
+	require.NoError(t, err)
+	_, err = d.VerifyProvenance(ctx, "signed")
+	require.True(t, errors.Is(err, ErrInvalidProvenance))
+
+	_, err = d.VerifyProvenance(ctx, "missing")
+	require.Equal(t, ErrNoSuchKey, err)
+}
+
+func TestDriveShare(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	_, err := d.Add(ctx, "docs/a", bytes.NewBufferString("a"))
+	require.NoError(t, err)
+
+	_, err = d.Share(ctx, "missing/", options.Share().SetToken(true))
+	require.Equal(t, ErrNoSuchKey, err)
+
+	// Keys merely containing the prefix are not shared.
+	_, err = d.Add(ctx, "x/docs/secret", bytes.NewBufferString("secret"))
+	require.NoError(t, err)
+	shared, _, err := d.(*drive).sharedFiles(ctx, "docs/")
+	require.NoError(t, err)
+	require.Len(t, shared, 1)
+	require.Equal(t, "docs/a", shared[0].Key)
+
+	s, err := d.Share(ctx, "docs/", options.Share().SetToken(true))
+	require.NoError(t, err)
+	require.NotEmpty(t, s.Token)
+
+	verified, err := d.VerifyShare(ctx, s.Token)
+	require.NoError(t, err)
+	require.Equal(t, "docs/", verified.Key)
+	require.Empty(t, verified.Token)
+
+	shares, err := d.ListShares(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Share{verified}, shares)
+
+	// Tamper with the claims of the token.
+	parts := strings.Split(s.Token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(mustDecodeBase64(t, parts[0]), "docs/", "other", 1))) + "." + parts[1]
+	_, err = d.VerifyShare(ctx, forged)
+	require.True(t, errors.Is(err, ErrInvalidShare))
+
+	require.NoError(t, d.Unshare(ctx, s.ID))
+	_, err = d.VerifyShare(ctx, s.Token)
+	require.True(t, errors.Is(err, ErrInvalidShare))
+	require.Equal(t, ErrNoSuchShare, d.Unshare(ctx, s.ID))
+
+	_, err = d.Share(ctx, "docs/a", options.Share().SetToken(true).SetTTL(-time.Second))
+	require.Error(t, err)
+
+	expired, err := d.Share(ctx, "docs/a", options.Share().SetToken(true).SetTTL(time.Second))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := d.VerifyShare(ctx, expired.Token)
+		return errors.Is(err, ErrInvalidShare)
+	}, 5*time.Second, 100*time.Millisecond)
+
+	revoked, err := d.(*drive).expireShares(ctx)
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+
+	// Publish the share to IPNS.
+	api := d.(*drive).api
+	published, err := d.Share(ctx, "docs/")
+	require.NoError(t, err)
+	require.NotEmpty(t, published.Name)
+	require.Empty(t, published.Token)
+
+	_, err = api.ResolvePath(ctx, path.Join(path.IpfsPath(published.Root), "a"))
+	require.NoError(t, err)
+	_, err = api.ResolvePath(ctx, path.Join(path.IpfsPath(published.Root), "x", "docs", "secret"))
+	require.Error(t, err)
+
+	// The root stays pinned while a published tree refers to it.
+	treeRoot, err := d.PublishTree(ctx, "docs/")
+	require.NoError(t, err)
+	require.Equal(t, published.Root, treeRoot)
+
+	require.NoError(t, d.Unshare(ctx, published.ID))
+	_, pinned, err := api.Pin().IsPinned(ctx, path.IpfsPath(published.Root))
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	require.True(t, validTreeName("a/b", map[string]bool{"a": true}))
+	require.False(t, validTreeName("a/b", map[string]bool{"a": false}))
+	require.False(t, validTreeName("a//b", map[string]bool{}))
+}
+
+func mustDecodeBase64(t *testing.T, s string) string {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestDrivePublishTree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	a, err := d.Add(ctx, "docs/a", bytes.NewBufferString("a"))
+	require.NoError(t, err)
+	b, err := d.Add(ctx, "docs/sub/b", bytes.NewBufferString("b"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "docs//c", bytes.NewBufferString("c"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "x/docs/secret", bytes.NewBufferString("secret"))
+	require.NoError(t, err)
+	content := []byte(strings.Repeat("2020-01-01 INFO request served\n", 1000))
+	compressed, err := d.Add(ctx, "docs/app.log", bytes.NewBuffer(content), options.Add().SetCompression(compress.Gzip))
+	require.NoError(t, err)
+	require.Equal(t, compress.Gzip, compressed.Compression)
+
+	_, err = d.PublishTree(ctx, "docs")
+	require.Error(t, err)
+
+	root, err := d.PublishTree(ctx, "docs/")
+	require.NoError(t, err)
+
+	api := d.(*drive).api
+	resolved, err := api.ResolvePath(ctx, path.Join(path.IpfsPath(root), "a"))
+	require.NoError(t, err)
+	require.Equal(t, a.Cid, resolved.Cid())
+
+	resolved, err = api.ResolvePath(ctx, path.Join(path.IpfsPath(root), "sub", "b"))
+	require.NoError(t, err)
+	require.Equal(t, b.Cid, resolved.Cid())
+
+	// Keys merely containing the prefix are not published.
+	_, err = api.ResolvePath(ctx, path.Join(path.IpfsPath(root), "x", "docs", "secret"))
+	require.Error(t, err)
+
+	// Compressed files are served decompressed.
+	node, err := api.Unixfs().Get(ctx, path.Join(path.IpfsPath(root), "app.log"))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(files.ToFile(node))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	refs, err := d.(*drive).references()
+	require.NoError(t, err)
+	require.Contains(t, refs, root)
+
+	// Publishing again replaces the previous tree.
+	require.NoError(t, d.Remove(ctx, "docs/a"))
+	next, err := d.PublishTree(ctx, "docs/")
+	require.NoError(t, err)
+	require.NotEqual(t, root, next)
+
+	_, err = api.ResolvePath(ctx, path.Join(path.IpfsPath(next), "a"))
+	require.Error(t, err)
+}
+
+func TestDriveDAGOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetDAG(options.DAG().SetCidVersion(1)))
+	defer cleanup()
+
+	f, err := d.Add(ctx, "default", bytes.NewBufferString("content"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), f.Cid.Prefix().Version)
+	require.Equal(t, DAGParams{
+		Chunker:    defaultChunker,
+		Layout:     options.LayoutBalanced,
+		RawLeaves:  true,
+		CidVersion: 1,
+		Hash:       defaultHash,
+	}, f.DAG)
+
+	dag := options.DAG().
+		SetChunker("size-1024").
+		SetLayout(options.LayoutTrickle).
+		SetHash("blake2b-256").
+		SetRawLeaves(false)
+	f, err = d.Add(ctx, "custom", bytes.NewBuffer(make([]byte, 4096)), options.Add().SetDAG(dag))
+	require.NoError(t, err)
+	require.Equal(t, uint64(mh.Names["blake2b-256"]), f.Cid.Prefix().MhType)
+	require.Equal(t, DAGParams{
+		Chunker:    "size-1024",
+		Layout:     options.LayoutTrickle,
+		RawLeaves:  false,
+		CidVersion: 1,
+		Hash:       "blake2b-256",
+	}, f.DAG)
+
+	_, err = d.Add(ctx, "bad", bytes.NewBufferString("content"), options.Add().SetDAG(options.DAG().SetLayout("unknown")))
+	require.Error(t, err)
+	_, err = d.Add(ctx, "bad", bytes.NewBufferString("content"), options.Add().SetDAG(options.DAG().SetCidVersion(0).SetHash("sha2-512")))
+	require.Error(t, err)
+
+	// Local files are hashed in the same way as the files in the drive.
+	dir, dirClean := mockTempDir(t, "dag")
+	defer dirClean()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "custom"), make([]byte, 4096), 0644))
+
+	dr, err := d.DiffDir(ctx, "", dir)
+	require.NoError(t, err)
+	for _, c := range dr.Changes() {
+		require.NotEqual(t, ChangeModified, c.Type, c.Key)
+	}
+}
+
+func TestDriveCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName, options.OpenDrive().SetCompression(compress.Gzip))
+	defer cleanup()
+
+	content := []byte(strings.Repeat("2020-01-01 INFO request served\n", 1000))
+
+	f, err := d.Add(ctx, "logs/app.log", bytes.NewBuffer(content))
+	require.NoError(t, err)
+	require.Equal(t, compress.Gzip, f.Compression)
+	require.Equal(t, int64(len(content)), f.Size)
+	require.True(t, f.StoredSize < f.Size)
+
+	rc, err := d.Get(ctx, "logs/app.log")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, content, data)
+
+	// Content compressed already is stored as is.
+	f, err = d.Add(ctx, "logs/app.log.gz", bytes.NewBuffer(content))
+	require.NoError(t, err)
+	require.Empty(t, f.Compression)
+	require.Equal(t, f.Size, f.StoredSize)
+
+	f, err = d.Add(ctx, "logs/raw.log", bytes.NewBuffer(content), options.Add().SetCompression(""))
+	require.NoError(t, err)
+	require.Empty(t, f.Compression)
+
+	_, err = d.Add(ctx, "logs/bad.log", bytes.NewBuffer(content), options.Add().SetCompression("unknown"))
+	require.Error(t, err)
+
+	// Local files are compressed in the same way to be compared.
+	dir, dirClean := mockTempDir(t, "compress")
+	defer dirClean()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app.log"), content, 0644))
+
+	dr, err := d.DiffDir(ctx, "logs/", dir)
+	require.NoError(t, err)
+	for _, c := range dr.Changes() {
+		require.NotEqual(t, ChangeModified, c.Type, c.Key)
+	}
+}
+
+func TestDriveUpload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	u, err := d.BeginUpload(ctx, "large", options.Add().SetIfNotExists(true))
+	require.NoError(t, err)
+
+	_, err = d.UploadPart(ctx, u.ID, 0, bytes.NewBufferString("x"))
+	require.Error(t, err)
+
+	// Parts may be uploaded in any order and uploaded again.
+	_, err = d.UploadPart(ctx, u.ID, 2, bytes.NewBufferString("world"))
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("hi "))
+	require.NoError(t, err)
+	p1, err := d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("hello "))
+	require.NoError(t, err)
+
+	// The upload survives a restart of the instance.
+	restarted, err := newDrive(d.(*drive).api, d.(*drive).db, d.(*drive).kv)
+	require.NoError(t, err)
+
+	uploads, err := restarted.ListUploads(ctx)
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Len(t, uploads[0].Parts, 2)
+	require.Equal(t, p1, uploads[0].Parts[0])
+
+	refs, err := restarted.references()
+	require.NoError(t, err)
+	require.Contains(t, refs, p1.Cid)
+
+	f, err := restarted.CompleteUpload(ctx, u.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(len("hello world")), f.Size)
+
+	rc, err := d.Get(ctx, "large")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, "hello world", string(data))
+
+	_, err = restarted.CompleteUpload(ctx, u.ID)
+	require.Equal(t, ErrNoSuchUpload, err)
+
+	// A rejected upload is kept until it is aborted.
+	u, err = d.BeginUpload(ctx, "other", options.Add().SetIfNotExists(true))
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("content"))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, "other", bytes.NewBufferString("winner"))
+	require.NoError(t, err)
+
+	_, err = d.CompleteUpload(ctx, u.ID)
+	require.True(t, errors.Is(err, ErrPreconditionFailed))
+	require.NoError(t, d.AbortUpload(ctx, u.ID))
+	require.Equal(t, ErrNoSuchUpload, d.AbortUpload(ctx, u.ID))
+
+	// The size of the stitched parts is checked against the expected size.
+	u, err = d.BeginUpload(ctx, "sized", options.Add().SetExpectedSize(4))
+	require.NoError(t, err)
+	_, err = d.UploadPart(ctx, u.ID, 1, bytes.NewBufferString("content"))
+	require.NoError(t, err)
+
+	_, err = d.CompleteUpload(ctx, u.ID)
+	require.True(t, errors.Is(err, ErrSizeMismatch))
+	require.NoError(t, d.AbortUpload(ctx, u.ID))
+
+	_, err = d.BeginUpload(ctx, "compressed", options.Add().SetCompression(compress.Gzip))
+	require.Error(t, err)
+
+	uploads, err = d.ListUploads(ctx)
+	require.NoError(t, err)
+	require.Empty(t, uploads)
+}
+
+func TestDriveAddSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+
+	var read int64
+	progress := func(n int64) {
+		atomic.StoreInt64(&read, n)
+	}
+
+	f, err := d.Add(ctx, "sized", bytes.NewReader(content), options.Add().
+		SetExpectedSize(int64(len(content))).
+		SetProgress(progress))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), f.Size)
+	require.Equal(t, f.Size, f.StoredSize)
+	require.Equal(t, int64(len(content)), atomic.LoadInt64(&read))
+
+	// Content whose size differs from the expected one is rejected.
+	_, err = d.Add(ctx, "short", bytes.NewReader(content[:10]), options.Add().SetExpectedSize(int64(len(content))))
+	require.True(t, errors.Is(err, ErrSizeMismatch))
+	_, err = d.Stat(ctx, "short")
+	require.Equal(t, ErrNoSuchKey, err)
+
+	// The original size is expected for compressed content.
+	f, err = d.Add(ctx, "sized.txt", bytes.NewReader(content), options.Add().
+		SetCompression(compress.Gzip).
+		SetExpectedSize(int64(len(content))))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), f.Size)
+	require.True(t, f.StoredSize < f.Size)
+}
+
+func TestDriveList(t *testing.T) {
+
+}
+
+func TestDriveRemove(t *testing.T) {
+
+}
+
+func TestDriveGrant(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t, mockDriveName)
+	defer cleanup()
+
+	grants, err := d.ListGrants(ctx)
+	require.NoError(t, err)
+
+	var own *Grant
+	for i := range grants {
+		if grants[i].Identity == d.Identity() {
+			own = &grants[i]
+		}
+	}
+	require.NotNil(t, own)
+	require.Contains(t, own.Permissions, PermissionWrite)
+
+	require.Equal(t, []Permission{PermissionRead, PermissionWrite}, RoleWriter.Permissions())
+	require.Equal(t, RoleWriter, roleOf(map[Permission]bool{PermissionRead: true, PermissionWrite: true}))
+	require.Equal(t, RoleAdmin, roleOf(map[Permission]bool{PermissionAdmin: true}))
+	require.Equal(t, "write", PermissionWrite.String())
+
+	err = d.Grant(ctx, "someone", Role(-1))
+	require.Error(t, err)
+
+	// Another identity is neither an admin nor a writer of the drive.
+	peer, peerClean := mockPeer(t, d, "peer")
+	defer peerClean()
+	require.NotEqual(t, d.Identity(), peer.Identity())
+
+	err = peer.Grant(ctx, peer.Identity(), RoleAdmin)
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.True(t, errors.Is(peer.Revoke(ctx, d.Identity()), ErrPermissionDenied))
+
+	_, err = peer.Add(ctx, "a", bytes.NewBufferString("1"))
+	var perr *PermissionError
+	require.True(t, errors.As(err, &perr))
+	require.Equal(t, peer.Identity(), perr.Identity)
+	require.Equal(t, PermissionWrite, perr.Permission)
 }
 
 func TestDriveRevoke(t *testing.T) {
@@ -0,0 +1,287 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/pkg/errors"
+)
+
+// GC finds the recursive pins which given drives have created on the ipfs node
+// but no longer reference, and returns them sorted. Unless dryRun is set, the
+// orphaned pins are removed, and then the repo garbage collection configured by
+// the options is run.
+//
+// Only the pins recorded by given drives are subject to collection, so content
+// pinned by other applications is left as is. Content recorded by other drives
+// kept in the same directory is never collected either, as it may be shared with
+// them. Content being added concurrently is pinned before it is referenced by
+// its drive, so GC should not run while writes are in flight.
+func GC(ctx context.Context, api coreiface.CoreAPI, drives []Instance, dryRun bool, opts ...*driveopts.GCOptions) ([]cid.Cid, error) {
+	opt := driveopts.MergeGCOptions(opts...)
+
+	var targets []*drive
+	for _, inst := range drives {
+		d, ok := inst.(*drive)
+		if !ok {
+			return nil, fmt.Errorf("unsupported drive instance %T", inst)
+		}
+		targets = append(targets, d)
+	}
+
+	// recorded holds the pins created by given drives, and protected holds the
+	// content which given drives refer to or other drives have pinned.
+	recorded := make(map[cid.Cid]bool)
+	protected := make(map[cid.Cid]bool)
+	logs := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, d := range targets {
+		refs, err := d.references()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range refs {
+			protected[c] = true
+		}
+
+		if len(d.pinLog) == 0 {
+			continue
+		}
+		cids, err := readPinLog(d.pinLog)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cids {
+			recorded[c] = true
+		}
+		logs[d.pinLog] = true
+		dirs[d.pinDir] = true
+	}
+
+	for dir := range dirs {
+		if err := walkPinLogs(dir, func(fpath string) error {
+			if logs[fpath] {
+				return nil
+			}
+			cids, err := readPinLog(fpath)
+			for _, c := range cids {
+				protected[c] = true
+			}
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	pins, err := api.Pin().Ls(ctx, options.Pin.Ls.Recursive())
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := make(map[cid.Cid]bool)
+	var orphans []cid.Cid
+	for p := range pins {
+		if err := p.Err(); err != nil {
+			return nil, err
+		}
+		c := p.Path().Cid()
+		pinned[c] = true
+		if recorded[c] && !protected[c] {
+			orphans = append(orphans, c)
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].String() < orphans[j].String()
+	})
+
+	if dryRun {
+		return orphans, nil
+	}
+
+	pin := api.Pin()
+	for i, c := range orphans {
+		if err := pin.Rm(ctx, path.IpfsPath(c), options.Pin.RmRecursive(true)); err != nil {
+			return orphans[:i], err
+		}
+		delete(pinned, c)
+	}
+
+	// Forget the recorded pins which are gone, including those unpinned when the
+	// drives released their content.
+	for _, d := range targets {
+		if len(d.pinLog) == 0 {
+			continue
+		}
+		if err := d.compactPinLog(func(c cid.Cid) bool {
+			return recorded[c] && !pinned[c]
+		}); err != nil {
+			return orphans, err
+		}
+	}
+
+	if opt.RepoGC != nil {
+		if err := opt.RepoGC(ctx); err != nil {
+			return orphans, err
+		}
+	}
+
+	return orphans, nil
+}
+
+// references returns the cids which the drive keeps pinned, including files in
+// the trash bin, the roots of snapshots, shares and published trees, and the
+// parts of pending uploads of this instance.
+func (d *drive) references() ([]cid.Cid, error) {
+	var refs []cid.Cid
+	for k, v := range d.kv.All() {
+		switch {
+		case strings.HasPrefix(k, snapshotPrefix):
+			s, err := decodeSnapshot(v)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, s.Cid)
+		case strings.HasPrefix(k, sharePrefix):
+			s, err := decodeShare(v)
+			if err != nil {
+				return nil, err
+			}
+			if s.Root.Defined() {
+				refs = append(refs, s.Root)
+			}
+		case strings.HasPrefix(k, treePrefix):
+			t, err := decodeTree(v)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, t.Root)
+		case strings.HasPrefix(k, trashPrefix):
+			e, err := decodeTrashEntry(v)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, e.File.Cid)
+		case isReserved(k):
+			continue
+		default:
+			f, err := decodeGob(v)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, f.Cid)
+		}
+	}
+
+	parts, err := d.uploadRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(refs, parts...), nil
+}
+
+// recordPin appends given cid to the pin log of the drive.
+func (d *drive) recordPin(c cid.Cid) error {
+	if len(d.pinLog) == 0 {
+		return nil
+	}
+
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(d.pinLog), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.pinLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, c.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// compactPinLog rewrites the pin log of the drive without the cids for which
+// drop returns true, and without duplicates.
+func (d *drive) compactPinLog(drop func(c cid.Cid) bool) error {
+	d.pinMu.Lock()
+	defer d.pinMu.Unlock()
+
+	cids, err := readPinLog(d.pinLog)
+	if err != nil || len(cids) == 0 {
+		return err
+	}
+
+	var buf bytes.Buffer
+	seen := make(map[cid.Cid]bool, len(cids))
+	for _, c := range cids {
+		if seen[c] || drop(c) {
+			continue
+		}
+		seen[c] = true
+		fmt.Fprintln(&buf, c.String())
+	}
+
+	tmp := d.pinLog + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.pinLog)
+}
+
+// readPinLog returns the cids recorded in the pin log at given path, which is
+// empty if the log does not exist.
+func readPinLog(fpath string) ([]cid.Cid, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cids []cid.Cid
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		c, err := cid.Decode(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pin log %s", fpath)
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+// walkPinLogs calls fn with the path of every pin log under given directory.
+func walkPinLogs(dir string, fn func(fpath string) error) error {
+	return filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(fpath) != ".log" {
+			return nil
+		}
+		return fn(fpath)
+	})
+}
@@ -0,0 +1,221 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func (d *drive) SetLifecycleRule(ctx context.Context, rule LifecycleRule) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+	if rule.ExpireAfter < 0 || rule.KeepLast < 0 {
+		return fmt.Errorf("lifecycle rule cannot hold negative values")
+	}
+
+	_, err := d.kv.Put(ctx, lifecyclePrefix+rule.Prefix, mustEncodeGob(rule))
+	return err
+}
+
+func (d *drive) RemoveLifecycleRule(ctx context.Context, prefix string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+
+	_, err := d.kv.Delete(ctx, lifecyclePrefix+prefix)
+	return err
+}
+
+func (d *drive) ListLifecycleRules(ctx context.Context) ([]LifecycleRule, error) {
+	var rules []LifecycleRule
+	for k, v := range d.kv.All() {
+		if !strings.HasPrefix(k, lifecyclePrefix) {
+			continue
+		}
+
+		var rule LifecycleRule
+		decoder := codec.Gob{}
+		if err := decoder.Unmarshal(v, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Prefix < rules[j].Prefix
+	})
+
+	return rules, nil
+}
+
+func (d *drive) Expire(ctx context.Context) ([]File, error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	rules, err := d.ListLifecycleRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for k, v := range d.kv.All() {
+		if isReserved(k) {
+			continue
+		}
+		f, err := decodeGob(v)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	candidates := expiredFiles(files, rules, time.Now())
+
+	var expired []File
+	for _, f := range candidates {
+		opt := driveopts.Remove().SetIfMatch(f.Cid).SetPermanent(true)
+		err := d.Remove(ctx, f.Key, opt)
+		switch {
+		case errors.Is(err, ErrPreconditionFailed):
+			// The file has been replaced or removed meanwhile.
+			d.logger.Debug("skip expired file changed meanwhile", zap.String("key", f.Key))
+			continue
+		case errors.Is(err, ErrPermissionDenied):
+			// Leave the file to an instance which may write it.
+			d.logger.Warn("skip expired file without permission", zap.String("key", f.Key), zap.Error(err))
+			continue
+		case err != nil:
+			return expired, err
+		}
+		expired = append(expired, f)
+	}
+
+	return expired, nil
+}
+
+// expiredFiles determines which files are expired at given time, either by
+// their own expiry time or by the lifecycle rules.
+func expiredFiles(files []File, rules []LifecycleRule, now time.Time) []File {
+	expired := make(map[string]File)
+
+	for _, f := range files {
+		if len(f.ExpiresAt) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC1123, f.ExpiresAt)
+		if err == nil && !now.Before(t) {
+			expired[f.Key] = f
+		}
+	}
+
+	for _, rule := range rules {
+		var matched []File
+		for _, f := range files {
+			if strings.HasPrefix(f.Key, rule.Prefix) {
+				matched = append(matched, f)
+			}
+		}
+
+		// Sort the files from the most recently added one.
+		sort.SliceStable(matched, func(i, j int) bool {
+			return addedAt(matched[i]).After(addedAt(matched[j]))
+		})
+
+		for i, f := range matched {
+			if rule.KeepLast > 0 && i >= rule.KeepLast {
+				expired[f.Key] = f
+			}
+			if rule.ExpireAfter > 0 && now.Sub(addedAt(f)) >= rule.ExpireAfter {
+				expired[f.Key] = f
+			}
+		}
+	}
+
+	ret := make([]File, 0, len(expired))
+	for _, f := range expired {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+
+	return ret
+}
+
+// addedAt returns the time the file was added. Files without a valid timestamp
+// are treated as added at the zero time.
+//
+// Timestamps are written in UTC, whose zone abbreviation parses the same on every
+// peer. Files added by older versions carry the local zone of the peer adding
+// them, which is taken as UTC unless the zone is known to the local peer.
+func addedAt(f File) time.Time {
+	t, _ := time.Parse(time.RFC1123, f.Timestamp)
+	return t.UTC()
+}
+
+func (d *drive) startJanitor(interval time.Duration) {
+	d.janitorStop = make(chan struct{})
+	d.janitorDone = make(chan struct{})
+
+	go func() {
+		defer close(d.janitorDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.janitorStop:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			expired, err := d.Expire(ctx)
+			cancel()
+
+			if err != nil {
+				d.logger.Warn("janitor failed to expire files", zap.Error(err))
+			}
+			if len(expired) != 0 {
+				d.logger.Debug("janitor expired files", zap.Int("count", len(expired)))
+			}
+
+			ctx, cancel = context.WithTimeout(context.Background(), interval)
+			shares, err := d.expireShares(ctx)
+			cancel()
+
+			if err != nil {
+				d.logger.Warn("janitor failed to expire shares", zap.Error(err))
+			}
+			if len(shares) != 0 {
+				d.logger.Debug("janitor expired shares", zap.Int("count", len(shares)))
+			}
+		}
+	}()
+}
+
+func (d *drive) stopJanitor() {
+	if d.janitorStop == nil {
+		return
+	}
+
+	close(d.janitorStop)
+	<-d.janitorDone
+	d.janitorStop = nil
+}
@@ -0,0 +1,66 @@
+package drive
+
+import (
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// manifest is an IPLD object recording the files of a drive at some point.
+// It links to the DAG of every file and carries the metadata of the files
+// verbatim, so that the drive can be recreated from it.
+type manifest struct {
+	Name      string
+	Label     string
+	Timestamp string
+	Files     []manifestEntry
+}
+
+type manifestEntry struct {
+	Key  string
+	Cid  cid.Cid
+	Meta []byte
+}
+
+func init() {
+	cbor.RegisterCborType(manifest{})
+	cbor.RegisterCborType(manifestEntry{})
+}
+
+// manifest builds the manifest of all files presenting in the drive.
+func (d *drive) manifest() (*manifest, error) {
+	m := &manifest{Name: d.Name()}
+	for k, v := range d.kv.All() {
+		if isReserved(k) {
+			continue
+		}
+
+		f, err := decodeGob(v)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, manifestEntry{Key: k, Cid: f.Cid, Meta: v})
+	}
+
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Key < m.Files[j].Key
+	})
+
+	return m, nil
+}
+
+// node encodes the manifest as a dag-cbor node.
+func (m *manifest) node() (*cbor.Node, error) {
+	return cbor.WrapObject(m, mh.SHA2_256, -1)
+}
+
+// decodeManifest decodes a manifest from a dag-cbor block.
+func decodeManifest(data []byte) (*manifest, error) {
+	m := &manifest{}
+	if err := cbor.DecodeInto(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
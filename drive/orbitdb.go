@@ -5,22 +5,75 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"berty.tech/go-orbit-db/iface"
 	"berty.tech/go-orbit-db/stores/basestore"
+	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pinning"
 	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
 )
 
 type drive struct {
+	// mu serializes conditional writes issued through this instance.
+	mu sync.Mutex
+
 	api coreiface.CoreAPI
 	db  iface.OrbitDB
 	kv  iface.KeyValueStore
+
+	// readOnly is set for instances opened from snapshots.
+	readOnly bool
+
+	// trash determines whether removed files are moved to the trash bin.
+	trash bool
+
+	logger *zap.Logger
+
+	// janitorStop and janitorDone control the janitor goroutine, which are nil
+	// if the janitor is not running.
+	janitorStop chan struct{}
+	janitorDone chan struct{}
+
+	// quota limits the usage of the whole drive, and ownerQuotas limit the usage
+	// of each owner.
+	quota       driveopts.Quota
+	ownerQuotas map[string]driveopts.Quota
+
+	// usage is nil until it is loaded on its first use, and is guarded by mu.
+	usage *usageTracker
+
+	// dag is the default of how the content of files is chunked and laid out.
+	dag *driveopts.DAGOptions
+
+	// compression is the algorithm to compress the content of files with by
+	// default, which is empty if disabled.
+	compression string
+
+	// uploadDir is the directory saving the state of uploads, and uploadMu
+	// serializes the updates of the state.
+	uploadDir string
+	uploadMu  sync.Mutex
+
+	// pinLog is the file recording the pins the drive creates on the local ipfs
+	// node, which lies in pinDir along with the logs of other drives kept in the
+	// same directory. pinMu serializes the updates of the log.
+	pinLog string
+	pinDir string
+	pinMu  sync.Mutex
+
+	// pinner pins the content of files in place of the local ipfs node if it
+	// is not nil.
+	pinner pinning.Backend
 }
 
 func (d *drive) Name() string {
@@ -35,94 +88,247 @@ func (d *drive) Identity() string {
 	return d.kv.Identity().ID
 }
 
-func (d *drive) AddFile(ctx context.Context, key, fpath string) (File, error) {
+func (d *drive) AddFile(ctx context.Context, key, fpath string, opts ...*driveopts.AddOptions) (File, error) {
 	if len(fpath) == 0 || len(key) == 0 {
 		return File{}, fmt.Errorf("Either key or fpath cannot be empty string")
 	}
 
-	node, err := openFileNode(fpath)
+	node, info, err := openFileNode(fpath)
+	if err != nil {
+		return File{}, err
+	}
+	defer node.Close()
+
+	opt := driveopts.MergeAddOptions(opts...)
+	if opt.ModTime == nil {
+		opt.SetModTime(info.ModTime())
+	}
+
+	return d.add(ctx, key, node, opt)
+}
+
+func (d *drive) Add(ctx context.Context, key string, r io.Reader, opts ...*driveopts.AddOptions) (File, error) {
+	if len(key) == 0 {
+		return File{}, fmt.Errorf("cannot use empty key")
+	}
+	if r == nil {
+		return File{}, fmt.Errorf("input stream is a nil pointer")
+	}
+
+	node := newFile(key, r)
+
+	return d.add(ctx, key, node, driveopts.MergeAddOptions(opts...))
+}
+
+func (d *drive) PutIf(ctx context.Context, key string, r io.Reader, expected cid.Cid) (File, error) {
+	opt := driveopts.Add()
+	if expected.Defined() {
+		opt.SetIfMatch(expected)
+	} else {
+		opt.SetIfNotExists(true)
+	}
+	return d.Add(ctx, key, r, opt)
+}
+
+func (d *drive) add(ctx context.Context, key string, node files.Node, opt *driveopts.AddOptions) (File, error) {
+	f, err := d.addContent(ctx, key, node, opt)
 	if err != nil {
 		return File{}, err
 	}
 
+	// Whether the file is rejected by a quota or by a precondition lost to a
+	// concurrent write, its content would be left orphaned.
+	if err := d.commit(ctx, f, opt); err != nil {
+		d.discard(ctx, f)
+		return File{}, err
+	}
+
+	return f, nil
+}
+
+// addContent pushes the content of the node to ipfs and returns the metadata
+// describing it. The metadata is not written to the drive until it is committed.
+func (d *drive) addContent(ctx context.Context, key string, node files.Node, opt *driveopts.AddOptions) (File, error) {
+	if d.readOnly {
+		return File{}, ErrReadOnly
+	}
+	if isReserved(key) {
+		return File{}, ErrReservedKey
+	}
+	if err := d.checkWrite(key); err != nil {
+		return File{}, err
+	}
+
+	// Fail fast before pushing any content to ipfs.
+	if err := d.checkPrecondition(ctx, key, isSet(opt.IfNotExists), opt.IfMatch); err != nil {
+		return File{}, err
+	}
 	size, err := node.Size()
+	if opt.ExpectedSize != nil {
+		size, err = *opt.ExpectedSize, nil
+	}
+	if err == nil && size > 0 {
+		if err := d.precheckQuota(ctx, key, size); err != nil {
+			return File{}, err
+		}
+	}
+
+	dag, unixfsOpts, err := dagParams(driveopts.MergeDAGOptions(d.dag, opt.DAG))
+	if err != nil {
+		return File{}, err
+	}
+	unixfsOpts = append(unixfsOpts, options.Unixfs.Pin(false))
+
+	algorithm := d.compression
+	if opt.Compression != nil {
+		algorithm = *opt.Compression
+	}
+	content, compressed, err := compressContent(key, withProgress(key, node, opt.Progress), algorithm)
 	if err != nil {
 		return File{}, err
 	}
+	if compressed != nil {
+		defer content.Close()
+	}
 
 	unixfs := d.api.Unixfs()
-	unixfsOpts := options.Unixfs.
-		Pin(true)
+	resolve, err := unixfs.Add(ctx, content, unixfsOpts...)
+	if err != nil {
+		return File{}, err
+	}
+
+	// The content is left pinned and unreferenced unless discarded on failure.
+	added := File{Key: key, Cid: resolve.Cid()}
+
+	if err := d.pin(ctx, resolve.Cid(), key); err != nil {
+		d.discard(ctx, added)
+		return File{}, err
+	}
 
-	resolve, err := unixfs.Add(ctx, node, unixfsOpts)
+	stored, err := d.fileSize(ctx, resolve)
 	if err != nil {
+		d.discard(ctx, added)
 		return File{}, err
 	}
 
-	f := File{
-		Key:       key,
-		Cid:       resolve.Cid(),
-		Size:      size,
-		Timestamp: time.Now().Format(time.RFC1123),
-		Owner:     d.Identity(),
+	size = stored
+	if compressed != nil {
+		size = compressed.src.n
+	}
+	if opt.ExpectedSize != nil && *opt.ExpectedSize != size {
+		d.discard(ctx, added)
+		return File{}, &SizeError{Key: key, Expected: *opt.ExpectedSize, Actual: size}
 	}
 
-	data := mustEncodeGob(f)
+	now := time.Now()
+	mtime := now
+	if opt.ModTime != nil {
+		mtime = *opt.ModTime
+	}
 
-	_, err = d.kv.Put(ctx, key, data)
-	if err != nil {
+	f := File{
+		Key:        key,
+		Cid:        resolve.Cid(),
+		Size:       size,
+		Timestamp:  now.UTC().Format(time.RFC1123),
+		Owner:      d.Identity(),
+		ModTime:    mtime.UTC().Format(time.RFC1123),
+		StoredSize: stored,
+		DAG:        dag,
+	}
+	if compressed != nil {
+		f.Compression = compressed.algorithm
+	}
+	if opt.ExpiresAt != nil {
+		f.ExpiresAt = opt.ExpiresAt.UTC().Format(time.RFC1123)
+	}
+
+	if err := d.sign(ctx, &f); err != nil {
+		d.discard(ctx, added)
 		return File{}, err
 	}
 
 	return f, nil
 }
 
-func (d *drive) Add(ctx context.Context, key string, r io.Reader) (File, error) {
-	if len(key) == 0 {
-		return File{}, fmt.Errorf("cannot use empty key")
-	}
-	if r == nil {
-		return File{}, fmt.Errorf("input stream is a nil pointer")
+// fileSize returns the size of the file at given path as recorded by its UnixFS
+// node, which does not depend on how its content was read while being added.
+func (d *drive) fileSize(ctx context.Context, p path.Path) (int64, error) {
+	node, err := d.api.Unixfs().Get(ctx, p)
+	if err != nil {
+		return 0, err
 	}
+	defer node.Close()
 
-	node := newFile(key, r)
+	return node.Size()
+}
 
-	unixfs := d.api.Unixfs()
-	unixfsOpts := options.Unixfs.
-		Pin(true)
+// commit writes the metadata of the file to the drive.
+func (d *drive) commit(ctx context.Context, f File, opt *driveopts.AddOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	resolve, err := unixfs.Add(ctx, node, unixfsOpts)
-	if err != nil {
-		return File{}, err
+	return d.put(ctx, f, opt)
+}
+
+// put writes the metadata of the file to the drive if the preconditions and the
+// quotas hold. The caller must hold d.mu.
+func (d *drive) put(ctx context.Context, f File, opt *driveopts.AddOptions) error {
+	// The key might be changed while adding the content, check it again.
+	if err := d.checkPrecondition(ctx, f.Key, isSet(opt.IfNotExists), opt.IfMatch); err != nil {
+		return err
 	}
 
-	size, err := node.Size()
+	old, err := d.current(ctx, f.Key)
 	if err != nil {
-		return File{}, err
+		return err
+	}
+	if err := d.checkQuota(old, f); err != nil {
+		return err
 	}
 
-	f := File{
-		Key:       key,
-		Cid:       resolve.Cid(),
-		Size:      size,
-		Timestamp: time.Now().Format(time.RFC1123),
-		Owner:     d.Identity(),
+	if _, err := d.kv.Put(ctx, f.Key, mustEncodeGob(f)); err != nil {
+		return err
 	}
 
-	data := mustEncodeGob(f)
+	d.track(old, &f)
+	return nil
+}
+
+// checkPrecondition verifies the current state of the key against the given
+// conditions. It returns a *PreconditionError if any of them does not hold.
+func (d *drive) checkPrecondition(ctx context.Context, key string, ifNotExists bool, ifMatch *cid.Cid) error {
+	if !ifNotExists && ifMatch == nil {
+		return nil
+	}
 
-	_, err = d.kv.Put(ctx, key, data)
+	current, err := d.current(ctx, key)
 	if err != nil {
-		return File{}, err
+		return err
 	}
 
-	return f, nil
+	actual := cid.Undef
+	if current != nil {
+		actual = current.Cid
+	}
+
+	if ifNotExists && current != nil {
+		return &PreconditionError{Key: key, Expected: cid.Undef, Actual: actual}
+	}
+	if ifMatch != nil && (current == nil || !actual.Equals(*ifMatch)) {
+		return &PreconditionError{Key: key, Expected: *ifMatch, Actual: actual}
+	}
+
+	return nil
 }
 
 func (d *drive) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	if len(key) == 0 {
 		return nil, fmt.Errorf("cannot use empty key")
 	}
+	if isReserved(key) {
+		return nil, ErrReservedKey
+	}
 
 	data, err := d.kv.Get(ctx, key)
 	if err != nil {
@@ -137,13 +343,21 @@ func (d *drive) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return files.ToFile(node), nil
+
+	rc := files.ToFile(node)
+	if len(f.Compression) != 0 {
+		return decompressFile(rc, f.Compression)
+	}
+	return rc, nil
 }
 
-func (d *drive) Stat(ctx context.Context, key string) (File, error) {
+func (d *drive) Stat(ctx context.Context, key string, opts ...*driveopts.StatOptions) (File, error) {
 	if len(key) == 0 {
 		return File{}, ErrEmptyKey
 	}
+	if isReserved(key) {
+		return File{}, ErrReservedKey
+	}
 
 	data, err := d.kv.Get(ctx, key)
 	if err != nil {
@@ -153,7 +367,20 @@ func (d *drive) Stat(ctx context.Context, key string) (File, error) {
 		return File{}, ErrNoSuchKey
 	}
 
-	return decodeGob(data)
+	f, err := decodeGob(data)
+	if err != nil {
+		return File{}, err
+	}
+
+	opt := driveopts.MergeStatOptions(opts...)
+	if d.pinner != nil && isSet(opt.Pins) {
+		f.Pins, err = d.pinner.Status(ctx, f.Cid)
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	return f, nil
 }
 
 func (d *drive) List(ctx context.Context, prefix string) (ListResult, error) {
@@ -161,6 +388,9 @@ func (d *drive) List(ctx context.Context, prefix string) (ListResult, error) {
 
 	var files []File
 	for k, v := range vals {
+		if isReserved(k) {
+			continue
+		}
 		if strings.Contains(k, prefix) {
 			f := mustDecodeGob(v)
 			files = append(files, f)
@@ -172,42 +402,139 @@ func (d *drive) List(ctx context.Context, prefix string) (ListResult, error) {
 	}, nil
 }
 
-func (d *drive) Remove(ctx context.Context, key string) error {
-	data, err := d.kv.Get(ctx, key)
+func (d *drive) Remove(ctx context.Context, key string, opts ...*driveopts.RemoveOptions) error {
+	opt := driveopts.MergeRemoveOptions(opts...)
+
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if isReserved(key) {
+		return ErrReservedKey
+	}
+	if err := d.checkWrite(key); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	f, trashed, err := d.remove(ctx, key, opt)
+	d.mu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	f := mustDecodeGob(data)
+	// The file is removed even if its content cannot be unpinned, which is left
+	// to GC.
+	if !trashed {
+		if err := d.release(ctx, f.Cid); err != nil {
+			d.logger.Warn("failed to unpin removed content", zap.String("key", f.Key), zap.Error(err))
+		}
+	}
+	return nil
+}
 
-	pin := d.api.Pin()
+// remove removes the file with given key from the drive, and moves it to the
+// trash bin if the trash bin is enabled and the removal is not permanent. It
+// reports whether the file has been moved to the trash bin, or otherwise leaves
+// its content to the caller to release. The caller must hold d.mu.
+func (d *drive) remove(ctx context.Context, key string, opt *driveopts.RemoveOptions) (File, bool, error) {
+	if err := d.checkPrecondition(ctx, key, false, opt.IfMatch); err != nil {
+		return File{}, false, err
+	}
 
-	_, ok, err := pin.IsPinned(ctx, path.IpfsPath(f.Cid))
+	current, err := d.current(ctx, key)
 	if err != nil {
-		return err
+		return File{}, false, err
+	}
+	if current == nil {
+		return File{}, false, ErrNoSuchKey
 	}
+	f := *current
 
-	if ok {
-		if err := pin.Rm(ctx, path.IpfsPath(f.Cid), options.Pin.RmRecursive(true)); err != nil {
+	trashed := d.trash && !isSet(opt.Permanent)
+	if trashed {
+		err = d.moveToTrash(ctx, f)
+	} else {
+		_, err = d.kv.Delete(ctx, key)
+	}
+	if err != nil {
+		return File{}, false, err
+	}
+
+	d.track(&f, nil)
+	return f, trashed, nil
+}
+
+// pin pins the content of given cid recursively, through the pinning backend
+// if the drive is configured with one. The name labels the pin. Pins on the
+// local ipfs node are recorded before they are created, so that GC collects
+// only the pins of the drive.
+func (d *drive) pin(ctx context.Context, c cid.Cid, name string) error {
+	if d.pinner == nil {
+		if err := d.recordPin(c); err != nil {
 			return err
 		}
 	}
+	return d.backend().Pin(ctx, c, name)
+}
 
-	_, err = d.kv.Delete(ctx, key)
-	return err
+// unpin removes the recursive pin of given cid if it presents.
+func (d *drive) unpin(ctx context.Context, c cid.Cid) error {
+	return d.backend().Unpin(ctx, c)
 }
 
-func (d *drive) Grant(ctx context.Context, keyID, permission string) error {
-	ac := d.kv.AccessController()
-	return ac.Grant(ctx, permission, keyID)
+// release unpins the content of given cid unless the drive still refers to it,
+// such as when another file, the trash bin or a snapshot shares the content.
+func (d *drive) release(ctx context.Context, c cid.Cid) error {
+	return d.releaseAll(ctx, []cid.Cid{c})
 }
 
-func (d *drive) Revoke(ctx context.Context, keyID, permission string) error {
-	ac := d.kv.AccessController()
-	return ac.Revoke(ctx, permission, keyID)
+// releaseAll releases the content of all given cids like release, while scanning
+// the references of the drive only once. It stops at the first failure.
+func (d *drive) releaseAll(ctx context.Context, cids []cid.Cid) error {
+	if len(cids) == 0 {
+		return nil
+	}
+
+	refs, err := d.references()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[cid.Cid]bool, len(refs))
+	for _, c := range refs {
+		referenced[c] = true
+	}
+
+	for _, c := range cids {
+		if referenced[c] {
+			continue
+		}
+		if err := d.unpin(ctx, c); err != nil {
+			return err
+		}
+		// The same content might be released more than once.
+		referenced[c] = true
+	}
+	return nil
+}
+
+// backend returns the pinning backend of the drive, which is the local ipfs
+// node by default.
+func (d *drive) backend() pinning.Backend {
+	if d.pinner != nil {
+		return d.pinner
+	}
+	return pinning.NewLocal(d.api.Pin())
 }
 
 func (d *drive) Close(ctx context.Context) error {
+	// A snapshot shares the stores with the drive it was opened from.
+	if d.readOnly {
+		return nil
+	}
+
+	d.stopJanitor()
+
 	// Save snapshopt.
 	_, err := basestore.SaveSnapshot(ctx, d.kv)
 	if err != nil {
@@ -225,27 +552,81 @@ func (d *drive) Close(ctx context.Context) error {
 	return nil
 }
 
-func newDrive(api coreiface.CoreAPI, db iface.OrbitDB, kv iface.KeyValueStore) (*drive, error) {
-	return &drive{
-		api: api,
-		db:  db,
-		kv:  kv,
-	}, nil
+func newDrive(api coreiface.CoreAPI, db iface.OrbitDB, kv iface.KeyValueStore, opts ...*driveopts.OpenDriveOptions) (*drive, error) {
+	opt := driveopts.MergeOpenDriveOptions(opts...)
+
+	bindAccessController(kv)
+
+	d := &drive{
+		api:         api,
+		db:          db,
+		kv:          kv,
+		trash:       isSet(opt.Trash),
+		logger:      opt.Logger,
+		ownerQuotas: opt.OwnerQuotas,
+		dag:         opt.DAG,
+	}
+	if opt.Compression != nil {
+		d.compression = *opt.Compression
+	}
+
+	dir := defaultDirectory
+	if opt.Directory != nil {
+		dir = *opt.Directory
+	}
+	d.uploadDir = filepath.Join(dir, "uploads", strings.TrimPrefix(kv.Address().String(), "/orbitdb/"))
+	d.pinDir = filepath.Join(dir, "pins")
+	d.pinLog = filepath.Join(d.pinDir, strings.TrimPrefix(kv.Address().String(), "/orbitdb/")) + ".log"
+	if opt.Quota != nil {
+		d.quota = *opt.Quota
+	}
+
+	d.pinner = opt.Pinning
+	if d.pinner == nil && opt.Cluster != nil {
+		var min, max int
+		if opt.ReplicationMin != nil {
+			min = *opt.ReplicationMin
+		}
+		if opt.ReplicationMax != nil {
+			max = *opt.ReplicationMax
+		}
+		d.pinner = pinning.NewCluster(opt.Cluster, min, max)
+	}
+	if d.logger == nil {
+		d.logger = zap.NewNop()
+	}
+
+	if opt.JanitorInterval != nil && *opt.JanitorInterval > 0 {
+		d.startJanitor(*opt.JanitorInterval)
+	}
+
+	return d, nil
 }
 
-func openFileNode(fpath string) (files.Node, error) {
+func openFileNode(fpath string) (files.Node, os.FileInfo, error) {
 	f, err := os.Open(fpath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	info, err := f.Stat()
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, nil, err
 	}
 
 	node := files.NewReaderStatFile(f, info)
-	return node, nil
+	return node, info, nil
+}
+
+// isReserved reports whether the key belongs to the namespace reserved for the
+// internal records of a drive.
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, reservedPrefix)
+}
+
+func isSet(flag *bool) bool {
+	return flag != nil && *flag
 }
 
 func mustEncodeGob(v interface{}) []byte {
@@ -0,0 +1,600 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"berty.tech/go-ipfs-log/identityprovider"
+	logiface "berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-orbit-db/accesscontroller"
+	"berty.tech/go-orbit-db/events"
+	"berty.tech/go-orbit-db/iface"
+	"github.com/ipfs/go-cid"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
+)
+
+// PathAccessControllerType is the type of the access controller which scopes
+// write permissions by key prefixes.
+const PathAccessControllerType = "ipfstor-path"
+
+// aclRule denotes a permission granted to an identity on the keys under the
+// prefix. An empty prefix covers the whole drive.
+type aclRule struct {
+	Identity   string
+	Prefix     string
+	Permission Permission
+}
+
+func aclKey(identity string, p Permission, prefix string) string {
+	return aclPrefix + identity + "/" + p.String() + "/" + prefix
+}
+
+func decodeACLRule(data []byte) (r aclRule, err error) {
+	decoder := codec.Gob{}
+	err = decoder.Unmarshal(data, &r)
+	return r, err
+}
+
+// pathAccessController authorizes each log entry by its key against the rules
+// stored in the drive itself, so that an identity may be restricted to write
+// only under some prefixes. The admins listed in the manifest may write any
+// key, and only admins may change the rules.
+//
+// Each entry is evaluated against the rules in effect at its position in the
+// log, which are replayed from the changes of rules ordered before it. So an
+// entry replicated along with the rule permitting it is accepted, and entries
+// written before a rule is revoked stay valid for peers replicating them later.
+// The changes of rules are indexed by their clocks as entries are accepted, so
+// that an entry is evaluated without walking the whole log.
+type pathAccessController struct {
+	events.EventEmitter
+
+	admins []string
+
+	mu     sync.RWMutex
+	store  iface.KeyValueStore
+	logger *zap.Logger
+
+	// ops caches the changes of rules decoded from log entries by their hashes.
+	// Entries changing no rule are cached as nil. index holds the changes of
+	// rules accepted so far ordered by their clocks, and indexed tells the hashes
+	// of their entries. All of them are guarded by opsMu.
+	opsMu   sync.Mutex
+	ops     map[cid.Cid]*aclOp
+	index   []*aclOp
+	indexed map[cid.Cid]bool
+}
+
+// aclOp denotes a change of a rule recorded by a log entry.
+type aclOp struct {
+	entry logiface.IPFSLogEntry
+	key   string
+	put   bool
+	clock logiface.IPFSLogLamportClock
+}
+
+// ruleSet tells whether the rule of given key presents.
+type ruleSet interface {
+	has(ctx context.Context, key string) (bool, error)
+}
+
+// storeRules looks up the rules in the current state of the store.
+type storeRules struct {
+	store iface.KeyValueStore
+}
+
+func (r storeRules) has(ctx context.Context, key string) (bool, error) {
+	if r.store == nil {
+		return false, nil
+	}
+	data, err := r.store.Get(ctx, key)
+	return data != nil, err
+}
+
+// replayedRules holds the rules replayed from the log.
+type replayedRules map[string]bool
+
+func (r replayedRules) has(ctx context.Context, key string) (bool, error) {
+	return r[key], nil
+}
+
+func newPathAccessController(ctx context.Context, db iface.BaseOrbitDB, params accesscontroller.ManifestParams, opts ...accesscontroller.Option) (accesscontroller.Interface, error) {
+	admins := params.GetAccess(PermissionAdmin.String())
+	if len(admins) == 0 {
+		admins = []string{db.Identity().ID}
+	}
+
+	return &pathAccessController{
+		admins:  admins,
+		logger:  zap.NewNop(),
+		ops:     make(map[cid.Cid]*aclOp),
+		indexed: make(map[cid.Cid]bool),
+	}, nil
+}
+
+// setStore binds the store holding the rules to the access controller.
+func (ac *pathAccessController) setStore(store iface.KeyValueStore) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.store = store
+}
+
+func (ac *pathAccessController) kv() (iface.KeyValueStore, error) {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+
+	if ac.store == nil {
+		return nil, fmt.Errorf("access controller is not bound to a drive")
+	}
+	return ac.store, nil
+}
+
+func (ac *pathAccessController) Type() string {
+	return PathAccessControllerType
+}
+
+func (ac *pathAccessController) GetAuthorizedByRole(role string) ([]string, error) {
+	want, err := parsePermission(role)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := append([]string{}, ac.admins...)
+
+	store, err := ac.kv()
+	if err != nil {
+		return ids, nil
+	}
+
+	for k, v := range store.All() {
+		if !strings.HasPrefix(k, aclPrefix) {
+			continue
+		}
+		r, err := decodeACLRule(v)
+		if err != nil {
+			return nil, err
+		}
+		// An admin has every permission, and a writer may read as well.
+		if len(r.Prefix) == 0 && r.Permission >= want {
+			ids = append(ids, r.Identity)
+		}
+	}
+
+	return ids, nil
+}
+
+func (ac *pathAccessController) Grant(ctx context.Context, capability string, keyID string) error {
+	p, err := parsePermission(capability)
+	if err != nil {
+		return err
+	}
+	store, err := ac.kv()
+	if err != nil {
+		return err
+	}
+
+	r := aclRule{Identity: keyID, Permission: p}
+	_, err = store.Put(ctx, aclKey(keyID, p, ""), mustEncodeGob(r))
+	return err
+}
+
+func (ac *pathAccessController) Revoke(ctx context.Context, capability string, keyID string) error {
+	p, err := parsePermission(capability)
+	if err != nil {
+		return err
+	}
+	store, err := ac.kv()
+	if err != nil {
+		return err
+	}
+
+	_, err = store.Delete(ctx, aclKey(keyID, p, ""))
+	return err
+}
+
+func (ac *pathAccessController) Load(ctx context.Context, address string) error {
+	return nil
+}
+
+func (ac *pathAccessController) Save(ctx context.Context) (accesscontroller.ManifestParams, error) {
+	return accesscontroller.NewSimpleManifestParams(PathAccessControllerType, map[string][]string{
+		PermissionAdmin.String(): ac.admins,
+	}), nil
+}
+
+func (ac *pathAccessController) Close() error {
+	return nil
+}
+
+func (ac *pathAccessController) SetLogger(logger *zap.Logger) {
+	ac.logger = logger
+}
+
+func (ac *pathAccessController) Logger() *zap.Logger {
+	return ac.logger
+}
+
+func (ac *pathAccessController) CanAppend(entry accesscontroller.LogEntry, p identityprovider.Interface, additionalContext accesscontroller.CanAppendAdditionalContext) error {
+	identity := entry.GetIdentity()
+	if identity == nil {
+		return fmt.Errorf("log entry has no identity")
+	}
+	if err := p.VerifyIdentity(identity); err != nil {
+		return err
+	}
+
+	op, err := decodeOp(entry.GetPayload())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	e, ok := entry.(logiface.IPFSLogEntry)
+	if !ok || e.GetClock() == nil {
+		return ac.authorize(ctx, identity.ID, op.key)
+	}
+
+	if err := ac.authorizeWith(ctx, ac.rulesAt(e.GetClock()), identity.ID, op.key); err != nil {
+		// The rule permitting the entry might be written by an entry which is
+		// not indexed yet, such as one joined along with it.
+		if !ac.indexKnown(additionalContext, p) {
+			return err
+		}
+		if err := ac.authorizeWith(ctx, ac.rulesAt(e.GetClock()), identity.ID, op.key); err != nil {
+			return err
+		}
+	}
+
+	if aop := ac.aclOpOf(e); aop != nil && ac.verify(e, p) {
+		ac.insert(aop)
+	}
+	return nil
+}
+
+// rulesAt returns the rules in effect right before given clock, by replaying
+// the indexed changes of rules ordered before it.
+func (ac *pathAccessController) rulesAt(clock logiface.IPFSLogLamportClock) replayedRules {
+	ac.opsMu.Lock()
+	defer ac.opsMu.Unlock()
+
+	n := sort.Search(len(ac.index), func(i int) bool {
+		return compareClocks(ac.index[i].clock, clock) >= 0
+	})
+
+	rules := make(replayedRules)
+	for _, op := range ac.index[:n] {
+		if op.put {
+			rules[op.key] = true
+		} else {
+			delete(rules, op.key)
+		}
+	}
+	return rules
+}
+
+// indexKnown indexes the changes of rules in the entries known by the log and by
+// the local replica which are not indexed yet, and reports whether any of them
+// is indexed. The changes are indexed in the order of their clocks, and only if
+// they are signed by their writers and permitted by the rules before them, since
+// they are not accepted by the log yet. This walks the whole log, so it is only
+// done when an entry would be rejected otherwise.
+func (ac *pathAccessController) indexKnown(additionalContext accesscontroller.CanAppendAdditionalContext, p identityprovider.Interface) bool {
+	var known []accesscontroller.LogEntry
+	if additionalContext != nil {
+		known = append(known, additionalContext.GetLogEntries()...)
+	}
+	if store, err := ac.kv(); err == nil {
+		for _, le := range store.OpLog().Values().Slice() {
+			known = append(known, le)
+		}
+	}
+
+	var pending []*aclOp
+	for _, le := range known {
+		e, ok := le.(logiface.IPFSLogEntry)
+		if !ok || e.GetClock() == nil || ac.isIndexed(e.GetHash()) {
+			continue
+		}
+		if op := ac.aclOpOf(e); op != nil {
+			pending = append(pending, op)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return compareClocks(pending[i].clock, pending[j].clock) < 0
+	})
+
+	added := false
+	for _, op := range pending {
+		if !ac.verify(op.entry, p) {
+			continue
+		}
+		identity := op.entry.GetIdentity()
+		if err := ac.authorizeWith(context.Background(), ac.rulesAt(op.clock), identity.ID, op.key); err != nil {
+			continue
+		}
+		if ac.insert(op) {
+			added = true
+		}
+	}
+	return added
+}
+
+// verify tells whether the entry is signed by the identity it carries. Entries
+// are indexed before the log verifies their signatures, which must not leave a
+// forged change of a rule indexed.
+func (ac *pathAccessController) verify(e logiface.IPFSLogEntry, p identityprovider.Interface) bool {
+	identity := e.GetIdentity()
+	if identity == nil || p.VerifyIdentity(identity) != nil {
+		return false
+	}
+	if err := e.Verify(p); err != nil {
+		ac.logger.Debug("skip indexing unverified entry", zap.String("hash", e.GetHash().String()), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// insert adds the change of a rule to the index unless it is indexed already,
+// and reports whether it is added.
+func (ac *pathAccessController) insert(op *aclOp) bool {
+	ac.opsMu.Lock()
+	defer ac.opsMu.Unlock()
+
+	hash := op.entry.GetHash()
+	if ac.indexed[hash] {
+		return false
+	}
+
+	i := sort.Search(len(ac.index), func(i int) bool {
+		return compareClocks(ac.index[i].clock, op.clock) > 0
+	})
+	ac.index = append(ac.index, nil)
+	copy(ac.index[i+1:], ac.index[i:])
+	ac.index[i] = op
+	ac.indexed[hash] = true
+	return true
+}
+
+func (ac *pathAccessController) isIndexed(hash cid.Cid) bool {
+	ac.opsMu.Lock()
+	defer ac.opsMu.Unlock()
+	return ac.indexed[hash]
+}
+
+// aclOpOf returns the change of a rule recorded by the entry, or nil if the
+// entry changes no rule. Entries which cannot be decoded change no rule either,
+// they are rejected on their own.
+func (ac *pathAccessController) aclOpOf(e logiface.IPFSLogEntry) *aclOp {
+	ac.opsMu.Lock()
+	defer ac.opsMu.Unlock()
+
+	if op, ok := ac.ops[e.GetHash()]; ok {
+		return op
+	}
+
+	var op *aclOp
+	if payload, err := decodeOp(e.GetPayload()); err == nil && strings.HasPrefix(payload.key, aclPrefix) {
+		op = &aclOp{
+			entry: e,
+			key:   payload.key,
+			put:   payload.op == "PUT",
+			clock: e.GetClock(),
+		}
+	}
+
+	ac.ops[e.GetHash()] = op
+	return op
+}
+
+// logOp denotes the operation recorded by a log entry of the drive.
+type logOp struct {
+	op  string
+	key string
+}
+
+func decodeOp(payload []byte) (logOp, error) {
+	var op struct {
+		Op  string  `json:"op"`
+		Key *string `json:"key"`
+	}
+	if err := json.Unmarshal(payload, &op); err != nil {
+		return logOp{}, err
+	}
+	if op.Key == nil {
+		return logOp{}, fmt.Errorf("log entry has no key")
+	}
+	return logOp{op: op.Op, key: *op.Key}, nil
+}
+
+// compareClocks orders entries in the same way as the log does, by their times
+// and then by the identities writing them.
+func compareClocks(a, b logiface.IPFSLogLamportClock) int {
+	if a.GetTime() != b.GetTime() {
+		if a.GetTime() < b.GetTime() {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a.GetID(), b.GetID())
+}
+
+// authorize verifies that the identity may write the key under the current
+// rules of the local replica. It returns a *PermissionError otherwise.
+func (ac *pathAccessController) authorize(ctx context.Context, identity, key string) error {
+	store, err := ac.kv()
+	if err != nil {
+		return err
+	}
+	return ac.authorizeWith(ctx, storeRules{store: store}, identity, key)
+}
+
+// authorizeWith verifies that the identity may write the key under given rules.
+// It returns a *PermissionError otherwise.
+func (ac *pathAccessController) authorizeWith(ctx context.Context, rules ruleSet, identity, key string) error {
+	admin, err := ac.isAdmin(ctx, rules, identity)
+	if err != nil {
+		return err
+	}
+	if admin {
+		return nil
+	}
+	if strings.HasPrefix(key, aclPrefix) {
+		return &PermissionError{Identity: identity, Permission: PermissionAdmin, Key: key}
+	}
+
+	// Moving a file to the trash bin requires the permission on the file
+	// itself, while other internal records require the permission on the
+	// whole drive.
+	target := key
+	prefixes := []string{""}
+	if strings.HasPrefix(key, trashPrefix) {
+		target = strings.TrimPrefix(key, trashPrefix)
+	}
+	if !isReserved(target) {
+		prefixes = keyPrefixes(target)
+	}
+
+	for _, id := range []string{identity, "*"} {
+		for _, prefix := range prefixes {
+			ok, err := rules.has(ctx, aclKey(id, PermissionWrite, prefix))
+			if err != nil {
+				return err
+			}
+			if ok {
+				return nil
+			}
+		}
+	}
+
+	return &PermissionError{Identity: identity, Permission: PermissionWrite, Key: key}
+}
+
+func (ac *pathAccessController) isAdmin(ctx context.Context, rules ruleSet, identity string) (bool, error) {
+	for _, id := range ac.admins {
+		if id == identity || id == "*" {
+			return true, nil
+		}
+	}
+
+	for _, id := range []string{identity, "*"} {
+		ok, err := rules.has(ctx, aclKey(id, PermissionAdmin, ""))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// keyPrefixes returns every directory prefix of the key, from the whole drive
+// to the innermost directory.
+func keyPrefixes(key string) []string {
+	prefixes := []string{""}
+	for i := range key {
+		if key[i] == '/' {
+			prefixes = append(prefixes, key[:i+1])
+		}
+	}
+	return prefixes
+}
+
+// bindAccessController binds the store to its access controller if the access
+// controller keeps its rules in the store.
+func bindAccessController(kv iface.KeyValueStore) {
+	if ac, ok := kv.AccessController().(*pathAccessController); ok {
+		ac.setStore(kv)
+	}
+}
+
+func (d *drive) GrantPrefix(ctx context.Context, identity, prefix string, perm Permission) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if perm != PermissionRead && perm != PermissionWrite {
+		return fmt.Errorf("cannot grant %s permission on a prefix", perm)
+	}
+	if err := validateScope(prefix); err != nil {
+		return err
+	}
+	if _, err := d.pathAccessController(); err != nil {
+		return err
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+
+	r := aclRule{Identity: identity, Prefix: prefix, Permission: perm}
+	_, err := d.kv.Put(ctx, aclKey(identity, perm, prefix), mustEncodeGob(r))
+	return err
+}
+
+func (d *drive) RevokePrefix(ctx context.Context, identity, prefix string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if err := validateScope(prefix); err != nil {
+		return err
+	}
+	if _, err := d.pathAccessController(); err != nil {
+		return err
+	}
+	if err := d.checkPermission(PermissionAdmin); err != nil {
+		return err
+	}
+
+	for _, p := range []Permission{PermissionRead, PermissionWrite} {
+		if _, err := d.kv.Delete(ctx, aclKey(identity, p, prefix)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scopes returns the prefix scoped permissions of each identity.
+func (d *drive) scopes() (map[string][]Scope, error) {
+	scopes := make(map[string][]Scope)
+	for k, v := range d.kv.All() {
+		if !strings.HasPrefix(k, aclPrefix) {
+			continue
+		}
+		r, err := decodeACLRule(v)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Prefix) != 0 {
+			scopes[r.Identity] = append(scopes[r.Identity], Scope{Prefix: r.Prefix, Permission: r.Permission})
+		}
+	}
+	return scopes, nil
+}
+
+func (d *drive) pathAccessController() (*pathAccessController, error) {
+	ac := d.kv.AccessController()
+	pac, ok := ac.(*pathAccessController)
+	if !ok {
+		return nil, fmt.Errorf("access controller %q does not support prefix scoped permissions", ac.Type())
+	}
+	return pac, nil
+}
+
+func validateScope(prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("prefix %q must end with a slash", prefix)
+	}
+	if isReserved(prefix) {
+		return ErrReservedKey
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package drive
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// Provenance proves the identity which wrote a file. The signing key of the
+// identity signs the key, cid, size and timestamp of the file, and the key of
+// the identity itself signs the signing key, so that the signature can be
+// verified with nothing but the Owner of the file.
+type Provenance struct {
+	// PublicKey is the signing key of the writer.
+	PublicKey []byte
+
+	// IDSignature is the signature of the writer id by the signing key.
+	IDSignature []byte
+
+	// KeySignature is the signature of the signing key and IDSignature by the
+	// key of the writer id.
+	KeySignature []byte
+
+	// Signature is the signature of the file by the signing key.
+	Signature []byte
+}
+
+// ProvenanceError denotes a file whose provenance cannot be verified, such as
+// one without signature or one whose metadata was altered after being signed.
+type ProvenanceError struct {
+	Key    string
+	Owner  string
+	Reason string
+}
+
+// Error implements error interface.
+func (e *ProvenanceError) Error() string {
+	return fmt.Sprintf("%v: key %q owned by %q: %s", ErrInvalidProvenance, e.Key, e.Owner, e.Reason)
+}
+
+// Is reports whether the error matches ErrInvalidProvenance.
+func (e *ProvenanceError) Is(target error) bool {
+	return target == ErrInvalidProvenance
+}
+
+func (d *drive) VerifyProvenance(ctx context.Context, key string) (File, error) {
+	f, err := d.Stat(ctx, key)
+	if err != nil {
+		return File{}, err
+	}
+
+	if err := verifyProvenance(f); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// sign records the provenance of the file with the identity of the instance.
+func (d *drive) sign(ctx context.Context, f *File) error {
+	p, err := d.signPayload(ctx, provenancePayload(*f))
+	if err != nil {
+		return err
+	}
+	f.Provenance = p
+	return nil
+}
+
+// signPayload signs the payload with the identity of the instance.
+func (d *drive) signPayload(ctx context.Context, payload []byte) (*Provenance, error) {
+	id := d.kv.Identity()
+	if id == nil || id.Provider == nil || id.Signatures == nil {
+		return nil, fmt.Errorf("identity of the instance cannot sign")
+	}
+
+	sig, err := id.Provider.Sign(ctx, id, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provenance{
+		PublicKey:    id.PublicKey,
+		IDSignature:  id.Signatures.ID,
+		KeySignature: id.Signatures.PublicKey,
+		Signature:    sig,
+	}, nil
+}
+
+// verifyProvenance verifies the provenance of the file against its owner, and
+// returns a *ProvenanceError if it does not hold.
+func verifyProvenance(f File) error {
+	if f.Provenance == nil {
+		return &ProvenanceError{Key: f.Key, Owner: f.Owner, Reason: "no provenance recorded"}
+	}
+	if reason := verifySignature(f.Owner, f.Provenance, provenancePayload(f)); len(reason) != 0 {
+		return &ProvenanceError{Key: f.Key, Owner: f.Owner, Reason: reason}
+	}
+	return nil
+}
+
+// verifySignature verifies that the payload is signed by the owner, and returns
+// the reason if it is not.
+func verifySignature(owner string, p *Provenance, payload []byte) string {
+	// The id of an orbitdb identity is the hex encoded key of the identity.
+	raw, err := hex.DecodeString(owner)
+	if err != nil {
+		return "owner is not a public key"
+	}
+	ownerKey, err := crypto.UnmarshalSecp256k1PublicKey(raw)
+	if err != nil {
+		return "owner is not a public key"
+	}
+
+	signer, err := crypto.UnmarshalSecp256k1PublicKey(p.PublicKey)
+	if err != nil {
+		return "invalid signing key"
+	}
+
+	if ok, err := ownerKey.Verify(append(append([]byte{}, p.PublicKey...), p.IDSignature...), p.KeySignature); err != nil || !ok {
+		return "signing key is not issued by the owner"
+	}
+	if ok, err := signer.Verify([]byte(owner), p.IDSignature); err != nil || !ok {
+		return "signing key is not bound to the owner"
+	}
+	if ok, err := signer.Verify(payload, p.Signature); err != nil || !ok {
+		return "metadata does not match the signature"
+	}
+
+	return ""
+}
+
+// provenancePayload returns the signed content of the file.
+func provenancePayload(f File) []byte {
+	data, _ := json.Marshal(struct {
+		Key       string
+		Cid       string
+		Size      int64
+		Timestamp string
+	}{
+		Key:       f.Key,
+		Cid:       f.Cid.String(),
+		Size:      f.Size,
+		Timestamp: f.Timestamp,
+	})
+	return data
+}
@@ -0,0 +1,191 @@
+package drive
+
+import (
+	"context"
+
+	driveopts "github.com/meowdada/ipfstor/options"
+	"go.uber.org/zap"
+)
+
+// usageTracker tracks the storage usage of a drive incrementally, so that quotas
+// can be enforced without scanning the whole drive on every write.
+type usageTracker struct {
+	total  Usage
+	owners map[string]Usage
+}
+
+// add accounts the file to the tracker if sign is positive, or discounts it if
+// sign is negative.
+func (t *usageTracker) add(f File, sign int64) {
+	t.total.Bytes += sign * f.Size
+	t.total.Files += sign
+
+	u := t.owners[f.Owner]
+	u.Bytes += sign * f.Size
+	u.Files += sign
+	if u.Files == 0 {
+		delete(t.owners, f.Owner)
+		return
+	}
+	t.owners[f.Owner] = u
+}
+
+func (d *drive) Usage(ctx context.Context) (UsageReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Rescan the drive to take the replicated entries into account.
+	d.usage = nil
+	t, err := d.loadUsage()
+	if err != nil {
+		return UsageReport{}, err
+	}
+
+	report := UsageReport{
+		Total:       t.total,
+		Owners:      make(map[string]Usage, len(t.owners)),
+		Quota:       d.quota,
+		OwnerQuotas: make(map[string]driveopts.Quota, len(d.ownerQuotas)),
+	}
+	for owner, u := range t.owners {
+		report.Owners[owner] = u
+	}
+	for owner, q := range d.ownerQuotas {
+		report.OwnerQuotas[owner] = q
+	}
+
+	return report, nil
+}
+
+func (d *drive) hasQuota() bool {
+	return d.quota != (driveopts.Quota{}) || len(d.ownerQuotas) != 0
+}
+
+// loadUsage returns the usage tracker of the drive, which is built by scanning
+// the drive on its first use. The caller must hold d.mu.
+func (d *drive) loadUsage() (*usageTracker, error) {
+	if d.usage != nil {
+		return d.usage, nil
+	}
+
+	t := &usageTracker{owners: make(map[string]Usage)}
+	for k, v := range d.kv.All() {
+		if isReserved(k) {
+			continue
+		}
+		f, err := decodeGob(v)
+		if err != nil {
+			return nil, err
+		}
+		t.add(f, 1)
+	}
+
+	d.usage = t
+	return t, nil
+}
+
+// track updates the usage tracker after old has been replaced by f. Either of
+// them is nil if the key was absent or has been removed. The caller must hold
+// d.mu.
+func (d *drive) track(old, f *File) {
+	if d.usage == nil {
+		// The tracker is loaded with the latest state on its first use.
+		return
+	}
+	if old != nil {
+		d.usage.add(*old, -1)
+	}
+	if f != nil {
+		d.usage.add(*f, 1)
+	}
+}
+
+// checkQuota verifies that replacing old by f does not exceed any quota. old is
+// nil if the key is absent. It returns a *QuotaError if any quota would be
+// exceeded. The caller must hold d.mu.
+func (d *drive) checkQuota(old *File, f File) error {
+	if !d.hasQuota() {
+		return nil
+	}
+
+	t, err := d.loadUsage()
+	if err != nil {
+		return err
+	}
+
+	total := t.total
+	if old != nil {
+		total.Bytes -= old.Size
+		total.Files--
+	}
+	total.Bytes += f.Size
+	total.Files++
+	if exceeds(d.quota, total) {
+		return &QuotaError{Quota: d.quota, Usage: total}
+	}
+
+	q, ok := d.ownerQuotas[f.Owner]
+	if !ok {
+		return nil
+	}
+
+	owned := t.owners[f.Owner]
+	if old != nil && old.Owner == f.Owner {
+		owned.Bytes -= old.Size
+		owned.Files--
+	}
+	owned.Bytes += f.Size
+	owned.Files++
+	if exceeds(q, owned) {
+		return &QuotaError{Owner: f.Owner, Quota: q, Usage: owned}
+	}
+
+	return nil
+}
+
+// precheckQuota fails fast if adding a file of given size under key would exceed
+// any quota, before its content is pushed to ipfs.
+func (d *drive) precheckQuota(ctx context.Context, key string, size int64) error {
+	if !d.hasQuota() {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, err := d.current(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return d.checkQuota(old, File{Key: key, Size: size, Owner: d.Identity()})
+}
+
+// current returns the file presenting with given key, or nil if the key is absent.
+func (d *drive) current(ctx context.Context, key string) (*File, error) {
+	data, err := d.kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	f, err := decodeGob(data)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// discard unpins the content of a file which has been rejected, unless another
+// file in the drive or in the trash bin shares the content.
+func (d *drive) discard(ctx context.Context, f File) {
+	if err := d.release(ctx, f.Cid); err != nil {
+		d.logger.Warn("failed to unpin rejected content", zap.String("key", f.Key), zap.Error(err))
+	}
+}
+
+func exceeds(q driveopts.Quota, u Usage) bool {
+	return (q.Bytes > 0 && u.Bytes > q.Bytes) || (q.Files > 0 && u.Files > q.Files)
+}
@@ -0,0 +1,392 @@
+package drive
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
+)
+
+// shareKeyPrefix is the prefix of the names of ipfs keys generated for shares.
+const shareKeyPrefix = "ipfstor-share-"
+
+// ShareError denotes a share token which has been rejected.
+type ShareError struct {
+	ID     string
+	Reason string
+}
+
+// Error implements error interface.
+func (e *ShareError) Error() string {
+	return fmt.Sprintf("%v: share %q: %s", ErrInvalidShare, e.ID, e.Reason)
+}
+
+// Is reports whether the error matches ErrInvalidShare.
+func (e *ShareError) Is(target error) bool {
+	return target == ErrInvalidShare
+}
+
+// shareClaims denotes the content of a share token.
+type shareClaims struct {
+	ID        string
+	Drive     string
+	Key       string
+	Owner     string
+	ExpiresAt string
+}
+
+func (d *drive) Share(ctx context.Context, key string, opts ...*driveopts.ShareOptions) (Share, error) {
+	if d.readOnly {
+		return Share{}, ErrReadOnly
+	}
+	if len(key) == 0 {
+		return Share{}, ErrEmptyKey
+	}
+	if isReserved(key) {
+		return Share{}, ErrReservedKey
+	}
+
+	opt := driveopts.MergeShareOptions(opts...)
+	if opt.ExpiresAt != nil && !opt.ExpiresAt.After(time.Now()) {
+		return Share{}, fmt.Errorf("expiry time %s of share is not in the future", opt.ExpiresAt.UTC().Format(time.RFC1123))
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Share{}, err
+	}
+	if err := d.checkWrite(sharePrefix + id); err != nil {
+		return Share{}, err
+	}
+
+	files, prefix, err := d.sharedFiles(ctx, key)
+	if err != nil {
+		return Share{}, err
+	}
+
+	s := Share{
+		ID:        id,
+		Key:       key,
+		Owner:     d.Identity(),
+		CreatedAt: time.Now().UTC().Format(time.RFC1123),
+	}
+	if opt.ExpiresAt != nil {
+		s.ExpiresAt = opt.ExpiresAt.UTC().Format(time.RFC1123)
+	}
+
+	if isSet(opt.Token) {
+		s.Token, err = d.issueToken(ctx, s)
+		if err != nil {
+			return Share{}, err
+		}
+	} else {
+		if err := d.publishShare(ctx, &s, prefix, files, opt); err != nil {
+			return Share{}, err
+		}
+	}
+
+	record := s
+	record.Token = ""
+	if _, err := d.kv.Put(ctx, sharePrefix+id, mustEncodeGob(record)); err != nil {
+		if len(s.Name) != 0 {
+			d.revokeName(ctx, s)
+			d.unpinShareRoot(ctx, s)
+		}
+		return Share{}, err
+	}
+
+	return s, nil
+}
+
+func (d *drive) Unshare(ctx context.Context, id string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	s, err := d.loadShare(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := d.checkWrite(sharePrefix + id); err != nil {
+		return err
+	}
+
+	if len(s.Name) != 0 {
+		if err := d.revokeName(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	if _, err := d.kv.Delete(ctx, sharePrefix+id); err != nil {
+		return err
+	}
+
+	// The record no longer refers to the root, so it can be unpinned.
+	if len(s.Name) != 0 {
+		d.unpinShareRoot(ctx, s)
+	}
+	return nil
+}
+
+func (d *drive) ListShares(ctx context.Context) ([]Share, error) {
+	var shares []Share
+	for k, v := range d.kv.All() {
+		if !strings.HasPrefix(k, sharePrefix) {
+			continue
+		}
+
+		s, err := decodeShare(v)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].Key != shares[j].Key {
+			return shares[i].Key < shares[j].Key
+		}
+		return shares[i].ID < shares[j].ID
+	})
+
+	return shares, nil
+}
+
+func (d *drive) VerifyShare(ctx context.Context, token string) (Share, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return Share{}, &ShareError{Reason: "malformed token"}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Share{}, &ShareError{Reason: "malformed token"}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Share{}, &ShareError{Reason: "malformed token"}
+	}
+
+	var claims shareClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Share{}, &ShareError{Reason: "malformed token"}
+	}
+	var p Provenance
+	if err := json.Unmarshal(sig, &p); err != nil {
+		return Share{}, &ShareError{ID: claims.ID, Reason: "malformed token"}
+	}
+
+	if claims.Drive != d.Address() {
+		return Share{}, &ShareError{ID: claims.ID, Reason: "issued for another drive"}
+	}
+	if reason := verifySignature(claims.Owner, &p, payload); len(reason) != 0 {
+		return Share{}, &ShareError{ID: claims.ID, Reason: reason}
+	}
+	if shareExpired(claims.ExpiresAt, time.Now()) {
+		return Share{}, &ShareError{ID: claims.ID, Reason: "expired"}
+	}
+
+	s, err := d.loadShare(ctx, claims.ID)
+	if err == ErrNoSuchShare {
+		return Share{}, &ShareError{ID: claims.ID, Reason: "revoked"}
+	}
+	if err != nil {
+		return Share{}, err
+	}
+	if s.Key != claims.Key || s.Owner != claims.Owner || s.ExpiresAt != claims.ExpiresAt {
+		return Share{}, &ShareError{ID: claims.ID, Reason: "does not match the share"}
+	}
+
+	return s, nil
+}
+
+// expireShares revokes the shares of the instance which have expired.
+func (d *drive) expireShares(ctx context.Context) ([]Share, error) {
+	shares, err := d.ListShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	identity := d.Identity()
+
+	var expired []Share
+	for _, s := range shares {
+		if s.Owner != identity || !shareExpired(s.ExpiresAt, now) {
+			continue
+		}
+		if err := d.Unshare(ctx, s.ID); err != nil {
+			return expired, err
+		}
+		expired = append(expired, s)
+	}
+
+	return expired, nil
+}
+
+// sharedFiles returns the files shared by the key, along with the prefix to trim
+// from their keys to name them in the directory tree.
+func (d *drive) sharedFiles(ctx context.Context, key string) ([]File, string, error) {
+	if strings.HasSuffix(key, "/") {
+		lr, err := d.List(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+		files := filesUnder(lr.Files(), key)
+		if len(files) == 0 {
+			return nil, "", ErrNoSuchKey
+		}
+		return files, key, nil
+	}
+
+	f, err := d.Stat(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return []File{f}, key[:strings.LastIndex(key, "/")+1], nil
+}
+
+// publishShare publishes a directory tree of the files to IPNS with a key
+// generated for the share.
+func (d *drive) publishShare(ctx context.Context, s *Share, prefix string, files []File, opt *driveopts.ShareOptions) error {
+	root, err := d.buildTree(ctx, prefix, files)
+	if err != nil {
+		return err
+	}
+	if err := d.pin(ctx, root.Cid(), "share "+s.ID); err != nil {
+		return err
+	}
+	s.Root = root.Cid()
+
+	if _, err := d.api.Key().Generate(ctx, shareKeyPrefix+s.ID); err != nil {
+		d.unpinShareRoot(ctx, *s)
+		return err
+	}
+
+	publishOpts := []options.NamePublishOption{options.Name.Key(shareKeyPrefix + s.ID)}
+	if opt.ExpiresAt != nil {
+		publishOpts = append(publishOpts, options.Name.ValidTime(time.Until(*opt.ExpiresAt)))
+	}
+
+	entry, err := d.api.Name().Publish(ctx, root, publishOpts...)
+	if err != nil {
+		d.api.Key().Remove(ctx, shareKeyPrefix+s.ID)
+		d.unpinShareRoot(ctx, *s)
+		return err
+	}
+
+	s.Name = "/ipns/" + entry.Name()
+	return nil
+}
+
+// revokeName points the IPNS name of the share to an empty directory, so that
+// the shared tree is no longer resolved by the name, and removes its key. The
+// root of the share stays pinned until its record is removed.
+func (d *drive) revokeName(ctx context.Context, s Share) error {
+	keys, err := d.api.Key().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, k := range keys {
+		if k.Name() == shareKeyPrefix+s.ID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("share %q is published by another ipfs node", s.ID)
+	}
+
+	empty, err := d.api.Object().New(ctx, options.Object.Type("unixfs-dir"))
+	if err != nil {
+		return err
+	}
+	if _, err := d.api.Name().Publish(ctx, path.IpfsPath(empty.Cid()), options.Name.Key(shareKeyPrefix+s.ID)); err != nil {
+		return err
+	}
+	_, err = d.api.Key().Remove(ctx, shareKeyPrefix+s.ID)
+	return err
+}
+
+// unpinShareRoot unpins the root of the share, unless the drive still refers to
+// it, e.g. by another share, a published tree or a snapshot. The share record
+// must have been removed already.
+func (d *drive) unpinShareRoot(ctx context.Context, s Share) {
+	if err := d.release(ctx, s.Root); err != nil {
+		d.logger.Warn("failed to unpin share", zap.String("id", s.ID), zap.Error(err))
+	}
+}
+
+// issueToken issues a capability token of the share, which is the claims of the
+// share and its signature, both encoded in base64.
+func (d *drive) issueToken(ctx context.Context, s Share) (string, error) {
+	payload, err := json.Marshal(shareClaims{
+		ID:        s.ID,
+		Drive:     d.Address(),
+		Key:       s.Key,
+		Owner:     s.Owner,
+		ExpiresAt: s.ExpiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p, err := d.signPayload(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+	sig, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (d *drive) loadShare(ctx context.Context, id string) (Share, error) {
+	data, err := d.kv.Get(ctx, sharePrefix+id)
+	if err != nil {
+		return Share{}, err
+	}
+	if data == nil {
+		return Share{}, ErrNoSuchShare
+	}
+	return decodeShare(data)
+}
+
+// shareExpired reports whether a share with given expiry time has expired. Shares
+// without expiry time never expire.
+func shareExpired(expiresAt string, now time.Time) bool {
+	if len(expiresAt) == 0 {
+		return false
+	}
+	t, err := time.Parse(time.RFC1123, expiresAt)
+	return err != nil || !now.Before(t)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func decodeShare(data []byte) (s Share, err error) {
+	decoder := codec.Gob{}
+	err = decoder.Unmarshal(data, &s)
+	return s, err
+}
@@ -0,0 +1,301 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores/operation"
+	"github.com/ipfs/go-cid"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
+)
+
+func (d *drive) Snapshot(ctx context.Context, label string) (cid.Cid, error) {
+	if d.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
+	if len(label) == 0 {
+		return cid.Undef, fmt.Errorf("snapshot label cannot be empty")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := snapshotPrefix + label
+	data, err := d.kv.Get(ctx, key)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if data != nil {
+		return cid.Undef, ErrSnapshotExists
+	}
+
+	m, err := d.manifest()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC1123)
+	m.Label = label
+	m.Timestamp = now
+
+	node, err := m.node()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err := d.api.Dag().Add(ctx, node); err != nil {
+		return cid.Undef, err
+	}
+
+	// Pin the snapshot recursively, so that the content of its files outlives
+	// their removal from the drive.
+	if err := d.pin(ctx, node.Cid(), "snapshot "+label); err != nil {
+		return cid.Undef, err
+	}
+
+	s := Snapshot{
+		Label:     label,
+		Cid:       node.Cid(),
+		Timestamp: now,
+		Owner:     d.Identity(),
+	}
+
+	if _, err := d.kv.Put(ctx, key, mustEncodeGob(s)); err != nil {
+		return cid.Undef, err
+	}
+
+	return s.Cid, nil
+}
+
+func (d *drive) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	for k, v := range d.kv.All() {
+		if !strings.HasPrefix(k, snapshotPrefix) {
+			continue
+		}
+
+		s, err := decodeSnapshot(v)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Label < snapshots[j].Label
+	})
+
+	return snapshots, nil
+}
+
+func (d *drive) OpenSnapshot(ctx context.Context, label string) (Instance, error) {
+	m, err := d.loadSnapshot(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(m.Files))
+	for _, e := range m.Files {
+		files[e.Key] = e.Meta
+	}
+
+	return &drive{
+		api: d.api,
+		db:  d.db,
+		kv: &snapshotStore{
+			KeyValueStore: d.kv,
+			name:          d.Name() + "@" + label,
+			files:         files,
+		},
+		readOnly: true,
+		logger:   d.logger,
+	}, nil
+}
+
+func (d *drive) RollbackTo(ctx context.Context, label string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	m, err := d.loadSnapshot(ctx, label)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make(map[string][]byte)
+	for k, v := range d.kv.All() {
+		if !isReserved(k) {
+			current[k] = v
+		}
+	}
+
+	wanted := make(map[string]bool, len(m.Files))
+	for _, e := range m.Files {
+		wanted[e.Key] = true
+	}
+
+	// Check every key to be written before changing anything.
+	for _, e := range m.Files {
+		if !bytes.Equal(current[e.Key], e.Meta) {
+			if err := d.checkWrite(e.Key); err != nil {
+				return err
+			}
+		}
+	}
+	for k := range current {
+		if !wanted[k] {
+			if err := d.checkWrite(k); err != nil {
+				return err
+			}
+		}
+	}
+	if err := d.checkRollbackQuota(m); err != nil {
+		return err
+	}
+
+	// Reload the usage on its next use rather than tracking each change.
+	d.usage = nil
+
+	// The content of replaced and removed files is released once the restored
+	// files refer to theirs.
+	var removed []cid.Cid
+	for _, e := range m.Files {
+		if bytes.Equal(current[e.Key], e.Meta) {
+			continue
+		}
+		if err := d.pin(ctx, e.Cid, e.Key); err != nil {
+			return err
+		}
+		if _, err := d.kv.Put(ctx, e.Key, e.Meta); err != nil {
+			return err
+		}
+		if old, ok := current[e.Key]; ok {
+			if f, err := decodeGob(old); err == nil {
+				removed = append(removed, f.Cid)
+			}
+		}
+	}
+
+	// Files added after the snapshot are removed in the same way as Remove.
+	for k := range current {
+		if wanted[k] {
+			continue
+		}
+
+		f, trashed, err := d.remove(ctx, k, driveopts.Remove())
+		if err != nil {
+			return err
+		}
+		if !trashed {
+			removed = append(removed, f.Cid)
+		}
+	}
+
+	if err := d.releaseAll(ctx, removed); err != nil {
+		d.logger.Warn("failed to unpin removed content", zap.Error(err))
+	}
+	return nil
+}
+
+// checkRollbackQuota verifies that the files of the manifest do not exceed any
+// quota, which is the usage of the drive once it is rolled back to them. It
+// returns a *QuotaError otherwise.
+func (d *drive) checkRollbackQuota(m *manifest) error {
+	if !d.hasQuota() {
+		return nil
+	}
+
+	t := &usageTracker{owners: make(map[string]Usage)}
+	for _, e := range m.Files {
+		f, err := decodeGob(e.Meta)
+		if err != nil {
+			return err
+		}
+		t.add(f, 1)
+	}
+
+	if exceeds(d.quota, t.total) {
+		return &QuotaError{Quota: d.quota, Usage: t.total}
+	}
+	for owner, q := range d.ownerQuotas {
+		if exceeds(q, t.owners[owner]) {
+			return &QuotaError{Owner: owner, Quota: q, Usage: t.owners[owner]}
+		}
+	}
+	return nil
+}
+
+// loadSnapshot loads the manifest of the snapshot with given label.
+func (d *drive) loadSnapshot(ctx context.Context, label string) (*manifest, error) {
+	data, err := d.kv.Get(ctx, snapshotPrefix+label)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNoSuchSnapshot
+	}
+
+	s, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := d.api.Dag().Get(ctx, s.Cid)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeManifest(node.RawData())
+}
+
+func decodeSnapshot(data []byte) (s Snapshot, err error) {
+	decoder := codec.Gob{}
+	err = decoder.Unmarshal(data, &s)
+	return s, err
+}
+
+// snapshotStore serves the files recorded in a snapshot as a read-only key
+// value store. Other methods are delegated to the store of the drive.
+type snapshotStore struct {
+	iface.KeyValueStore
+	name  string
+	files map[string][]byte
+}
+
+func (s *snapshotStore) DBName() string {
+	return s.name
+}
+
+func (s *snapshotStore) All() map[string][]byte {
+	return s.files
+}
+
+func (s *snapshotStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.files[key], nil
+}
+
+func (s *snapshotStore) Put(ctx context.Context, key string, value []byte) (operation.Operation, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *snapshotStore) Delete(ctx context.Context, key string) (operation.Operation, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *snapshotStore) Drop() error {
+	return ErrReadOnly
+}
+
+func (s *snapshotStore) Close() error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package drive
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/go-cid"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/format"
+)
+
+const (
+	defaultStatsPrefixDepth = 1
+	defaultStatsLargest     = 10
+)
+
+// Stats denotes the statistics of files presenting in a drive. Total counts the
+// logical size of all files, while Unique counts each distinct content once.
+// Prefixes groups files by the directories of their keys up to the prefix depth,
+// where files at the top level are grouped under the empty prefix.
+type Stats struct {
+	Total    Usage
+	Unique   Usage
+	Owners   map[string]Usage
+	Prefixes map[string]Usage
+	Largest  []File
+}
+
+// Bytes marshals the statistics into a table.
+func (s *Stats) Bytes() []byte {
+	var rows []format.Row
+
+	appendRow := func(scope, name string, u Usage) {
+		rows = append(rows, format.Row{
+			{Key: "Scope", Value: scope},
+			{Key: "Name", Value: name},
+			{Key: "Files", Value: u.Files},
+			{Key: "Size", Value: humanize.IBytes(uint64(u.Bytes))},
+		})
+	}
+
+	appendRow("total", "", s.Total)
+	appendRow("unique", "", s.Unique)
+	for _, owner := range sortedKeys(s.Owners) {
+		appendRow("owner", owner, s.Owners[owner])
+	}
+	for _, prefix := range sortedKeys(s.Prefixes) {
+		name := prefix
+		if len(name) == 0 {
+			name = "<root>"
+		}
+		appendRow("prefix", name, s.Prefixes[prefix])
+	}
+	for _, f := range s.Largest {
+		appendRow("largest", f.Key, Usage{Bytes: f.Size, Files: 1})
+	}
+
+	tmpl := format.Basic{}
+	return tmpl.Render(rows, format.Options{})
+}
+
+func (d *drive) Stats(ctx context.Context, opts ...*driveopts.StatsOptions) (Stats, error) {
+	opt := driveopts.MergeStatsOptions(opts...)
+
+	depth := defaultStatsPrefixDepth
+	if opt.PrefixDepth != nil {
+		depth = *opt.PrefixDepth
+	}
+	largest := defaultStatsLargest
+	if opt.Largest != nil {
+		largest = *opt.Largest
+	}
+
+	s := Stats{
+		Owners:   make(map[string]Usage),
+		Prefixes: make(map[string]Usage),
+	}
+	seen := make(map[cid.Cid]bool)
+
+	var files []File
+	for k, v := range d.kv.All() {
+		if isReserved(k) {
+			continue
+		}
+		f, err := decodeGob(v)
+		if err != nil {
+			return Stats{}, err
+		}
+		files = append(files, f)
+
+		s.Total = addUsage(s.Total, f)
+		s.Owners[f.Owner] = addUsage(s.Owners[f.Owner], f)
+
+		prefix := keyPrefix(f.Key, depth)
+		s.Prefixes[prefix] = addUsage(s.Prefixes[prefix], f)
+
+		if !seen[f.Cid] {
+			seen[f.Cid] = true
+			s.Unique = addUsage(s.Unique, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Key < files[j].Key
+	})
+	if len(files) > largest {
+		files = files[:largest]
+	}
+	s.Largest = files
+
+	return s, nil
+}
+
+func addUsage(u Usage, f File) Usage {
+	u.Bytes += f.Size
+	u.Files++
+	return u
+}
+
+// keyPrefix returns the directories of the key up to given depth, including the
+// trailing slash. It returns an empty string if the key is at the top level.
+func keyPrefix(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		idx := strings.Index(key[end:], "/")
+		if idx < 0 {
+			break
+		}
+		end += idx + 1
+	}
+	return key[:end]
+}
+
+func sortedKeys(m map[string]Usage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -18,6 +18,35 @@ func newFile(key string, r io.Reader) files.Node {
 	return files.NewReaderStatFile(s, si)
 }
 
+// withProgress returns a node reporting the number of bytes read from the content
+// of the node to fn, or the node itself if fn is nil.
+func withProgress(key string, node files.Node, fn func(int64)) files.Node {
+	if fn == nil {
+		return node
+	}
+	f := files.ToFile(node)
+	if f == nil {
+		return node
+	}
+	return newFile(key, &progressReader{r: f, fn: fn})
+}
+
+// progressReader reports the number of bytes read through it so far.
+type progressReader struct {
+	r  io.Reader
+	n  int64
+	fn func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.fn(p.n)
+	}
+	return n, err
+}
+
 type stream struct {
 	r    io.Reader
 	info *streamInfo
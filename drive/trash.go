@@ -0,0 +1,186 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
+)
+
+// trashRecord is an entry of the trash bin along with the key of its record.
+type trashRecord struct {
+	key   string
+	entry TrashEntry
+}
+
+func (d *drive) ListTrash(ctx context.Context) ([]TrashEntry, error) {
+	records, err := d.trashRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TrashEntry, len(records))
+	for i, r := range records {
+		entries[i] = r.entry
+	}
+	return entries, nil
+}
+
+func (d *drive) Restore(ctx context.Context, key string) (File, error) {
+	if d.readOnly {
+		return File{}, ErrReadOnly
+	}
+	if len(key) == 0 {
+		return File{}, ErrEmptyKey
+	}
+	if err := d.checkWrite(key); err != nil {
+		return File{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	records, err := d.trashRecords()
+	if err != nil {
+		return File{}, err
+	}
+
+	// Records are sorted from the oldest version of each key.
+	var latest *trashRecord
+	for i := range records {
+		if records[i].entry.File.Key == key {
+			latest = &records[i]
+		}
+	}
+	if latest == nil {
+		return File{}, ErrNoSuchKey
+	}
+
+	f := latest.entry.File
+	if err := d.checkPrecondition(ctx, key, true, nil); err != nil {
+		return File{}, err
+	}
+	if err := d.checkQuota(nil, f); err != nil {
+		return File{}, err
+	}
+
+	if _, err := d.kv.Put(ctx, key, mustEncodeGob(f)); err != nil {
+		return File{}, err
+	}
+
+	if _, err := d.kv.Delete(ctx, latest.key); err != nil {
+		return File{}, err
+	}
+
+	d.track(nil, &f)
+	return f, nil
+}
+
+func (d *drive) PurgeTrash(ctx context.Context, olderThan time.Duration) ([]TrashEntry, error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	records, err := d.trashRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-olderThan)
+
+	var purged []TrashEntry
+	var cids []cid.Cid
+	for _, r := range records {
+		deletedAt, err := time.Parse(time.RFC1123, r.entry.DeletedAt)
+		if err == nil && deletedAt.After(deadline) {
+			continue
+		}
+
+		if _, err := d.kv.Delete(ctx, r.key); err != nil {
+			d.releaseTrash(ctx, cids)
+			return purged, err
+		}
+		purged = append(purged, r.entry)
+		cids = append(cids, r.entry.File.Cid)
+	}
+
+	d.releaseTrash(ctx, cids)
+	return purged, nil
+}
+
+// releaseTrash releases the content of purged entries, which might be shared with
+// a live file, another version in the trash bin, a snapshot or a share.
+func (d *drive) releaseTrash(ctx context.Context, cids []cid.Cid) {
+	if err := d.releaseAll(ctx, cids); err != nil {
+		d.logger.Warn("failed to unpin purged content", zap.Error(err))
+	}
+}
+
+// moveToTrash moves the file to the trash bin. Every removed version of a key is
+// kept in the trash bin as a separate entry until it is purged.
+func (d *drive) moveToTrash(ctx context.Context, f File) error {
+	now := time.Now()
+
+	// The pin status is not part of the record.
+	f.Pins = nil
+
+	e := TrashEntry{
+		File:      f,
+		DeletedAt: now.UTC().Format(time.RFC1123),
+	}
+
+	if _, err := d.kv.Put(ctx, trashKey(f.Key, now), mustEncodeGob(e)); err != nil {
+		return err
+	}
+
+	_, err := d.kv.Delete(ctx, f.Key)
+	return err
+}
+
+// trashRecords returns the entries of the trash bin sorted by key, and then from
+// the oldest version of each key.
+func (d *drive) trashRecords() ([]trashRecord, error) {
+	var records []trashRecord
+	for k, v := range d.kv.All() {
+		if !strings.HasPrefix(k, trashPrefix) {
+			continue
+		}
+
+		e, err := decodeTrashEntry(v)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, trashRecord{key: k, entry: e})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].entry.File.Key != records[j].entry.File.Key {
+			return records[i].entry.File.Key < records[j].entry.File.Key
+		}
+		return records[i].key < records[j].key
+	})
+
+	return records, nil
+}
+
+// trashKey returns the key of the record of the version of given key removed at
+// given time. The time is padded to a fixed width, so that the records of a key
+// sort by the time. Records written by older versions are keyed by the key alone,
+// which sort before any of them.
+func trashKey(key string, t time.Time) string {
+	return fmt.Sprintf("%s%s@%020d", trashPrefix, key, t.UnixNano())
+}
+
+func decodeTrashEntry(data []byte) (e TrashEntry, err error) {
+	decoder := codec.Gob{}
+	err = decoder.Unmarshal(data, &e)
+	return e, err
+}
@@ -0,0 +1,210 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	driveopts "github.com/meowdada/ipfstor/options"
+	"github.com/meowdada/ipfstor/pkg/codec"
+	"go.uber.org/zap"
+)
+
+// tree denotes the record of a published directory tree, which keeps the tree
+// pinned.
+type tree struct {
+	Prefix    string
+	Root      cid.Cid
+	Name      string
+	Timestamp string
+}
+
+func (d *drive) PublishTree(ctx context.Context, prefix string, opts ...*driveopts.PublishTreeOptions) (cid.Cid, error) {
+	if d.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
+	if len(prefix) != 0 && !strings.HasSuffix(prefix, "/") {
+		return cid.Undef, fmt.Errorf("prefix %q must end with a slash", prefix)
+	}
+	if isReserved(prefix) {
+		return cid.Undef, ErrReservedKey
+	}
+	if err := d.checkWrite(treePrefix + prefix); err != nil {
+		return cid.Undef, err
+	}
+
+	opt := driveopts.MergePublishTreeOptions(opts...)
+
+	lr, err := d.List(ctx, prefix)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	root, err := d.buildTree(ctx, prefix, filesUnder(lr.Files(), prefix))
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := d.pin(ctx, root.Cid(), "tree "+prefix); err != nil {
+		return cid.Undef, err
+	}
+
+	t := tree{
+		Prefix:    prefix,
+		Root:      root.Cid(),
+		Timestamp: time.Now().UTC().Format(time.RFC1123),
+	}
+
+	if opt.Key != nil {
+		entry, err := d.api.Name().Publish(ctx, root, options.Name.Key(*opt.Key))
+		if err != nil {
+			return cid.Undef, err
+		}
+		t.Name = "/ipns/" + entry.Name()
+	}
+
+	data, err := d.kv.Get(ctx, treePrefix+prefix)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if _, err := d.kv.Put(ctx, treePrefix+prefix, mustEncodeGob(t)); err != nil {
+		return cid.Undef, err
+	}
+
+	// Unpin the tree published previously, unless the drive still refers to it.
+	if data != nil {
+		old, err := decodeTree(data)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := d.release(ctx, old.Root); err != nil {
+			d.logger.Warn("failed to unpin previous tree", zap.String("prefix", prefix), zap.Error(err))
+		}
+	}
+
+	return t.Root, nil
+}
+
+// filesUnder returns the files whose keys start with prefix. List matches every
+// key containing the prefix, whose files must not leak into a tree of the prefix.
+func filesUnder(files []File, prefix string) []File {
+	var under []File
+	for _, f := range files {
+		if strings.HasPrefix(f.Key, prefix) {
+			under = append(under, f)
+		}
+	}
+	return under
+}
+
+// buildTree builds a UnixFS directory DAG of the files, where each file is named
+// by the remaining part of its key after prefix. The content of the files is
+// linked by their cids as is, except for compressed files, whose decompressed
+// content is added with the DAG parameters of the file and linked instead, so
+// that the tree serves the original content. Files whose names cannot be
+// represented in the tree, such as ones with empty path segments or ones
+// conflicting with a directory, are left out.
+func (d *drive) buildTree(ctx context.Context, prefix string, files []File) (path.Resolved, error) {
+	object := d.api.Object()
+
+	node, err := object.New(ctx, options.Object.Type("unixfs-dir"))
+	if err != nil {
+		return nil, err
+	}
+	root := path.IpfsPath(node.Cid())
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	// names records the kind of each path in the tree, which is true for
+	// directories and false for files.
+	names := make(map[string]bool)
+
+	for _, f := range files {
+		name := strings.TrimPrefix(f.Key, prefix)
+		if !validTreeName(name, names) {
+			d.logger.Warn("leave out file from directory tree", zap.String("key", f.Key))
+			continue
+		}
+
+		link, err := d.treeContent(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+
+		root, err = object.AddLink(ctx, root, name, link, options.Object.Create(true))
+		if err != nil {
+			return nil, err
+		}
+
+		segments := strings.Split(name, "/")
+		for i := 1; i < len(segments); i++ {
+			names[strings.Join(segments[:i], "/")] = true
+		}
+		names[name] = false
+	}
+
+	return root, nil
+}
+
+// validTreeName reports whether the name can be added to the tree holding given
+// names.
+func validTreeName(name string, names map[string]bool) bool {
+	if _, ok := names[name]; ok {
+		return false
+	}
+
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		if len(seg) == 0 || seg == "." || seg == ".." {
+			return false
+		}
+		if isDir, ok := names[strings.Join(segments[:i+1], "/")]; ok && !isDir {
+			return false
+		}
+	}
+
+	return true
+}
+
+func decodeTree(data []byte) (t tree, err error) {
+	decoder := codec.Gob{}
+	err = decoder.Unmarshal(data, &t)
+	return t, err
+}
+
+// treeContent returns the path of the content of the file to link in a directory
+// tree. The decompressed content of a compressed file is not pinned by itself but
+// by the pin of the tree.
+func (d *drive) treeContent(ctx context.Context, f File) (path.Resolved, error) {
+	if len(f.Compression) == 0 {
+		return path.IpfsPath(f.Cid), nil
+	}
+
+	_, unixfsOpts, err := dagParams(f.DAG.options())
+	if err != nil {
+		return nil, err
+	}
+	unixfsOpts = append(unixfsOpts, options.Unixfs.Pin(false))
+
+	unixfs := d.api.Unixfs()
+	node, err := unixfs.Get(ctx, path.IpfsPath(f.Cid))
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := decompressFile(files.ToFile(node), f.Compression)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return unixfs.Add(ctx, files.NewReaderFile(rc), unixfsOpts...)
+}
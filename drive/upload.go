@@ -0,0 +1,471 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	driveopts "github.com/meowdada/ipfstor/options"
+	mh "github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// maxUploadParts is the maximum number of parts of an upload, which keeps the
+// node stitching the parts within the size limit of a block.
+const maxUploadParts = 10000
+
+// uploadState is the state of an upload saved in the drive directory.
+type uploadState struct {
+	ID        string
+	Key       string
+	Options   *driveopts.AddOptions
+	Parts     map[int]Part
+	CreatedAt string
+}
+
+func (s *uploadState) upload() Upload {
+	u := Upload{
+		ID:        s.ID,
+		Key:       s.Key,
+		CreatedAt: s.CreatedAt,
+	}
+	for _, p := range s.Parts {
+		u.Parts = append(u.Parts, p)
+	}
+	sort.Slice(u.Parts, func(i, j int) bool {
+		return u.Parts[i].Number < u.Parts[j].Number
+	})
+	return u
+}
+
+// size returns the total size of the parts uploaded so far, except the n-th part
+// which is about to be replaced.
+func (s *uploadState) size(n int) int64 {
+	var size int64
+	for _, p := range s.Parts {
+		if p.Number != n {
+			size += p.Size
+		}
+	}
+	return size
+}
+
+func (d *drive) BeginUpload(ctx context.Context, key string, opts ...*driveopts.AddOptions) (Upload, error) {
+	if d.readOnly {
+		return Upload{}, ErrReadOnly
+	}
+	if len(key) == 0 {
+		return Upload{}, ErrEmptyKey
+	}
+	if isReserved(key) {
+		return Upload{}, ErrReservedKey
+	}
+	if err := d.checkWrite(key); err != nil {
+		return Upload{}, err
+	}
+
+	opt := driveopts.MergeAddOptions(opts...)
+	if opt.Compression != nil && len(*opt.Compression) != 0 {
+		return Upload{}, fmt.Errorf("uploads do not support compression")
+	}
+	if err := d.checkPrecondition(ctx, key, isSet(opt.IfNotExists), opt.IfMatch); err != nil {
+		return Upload{}, err
+	}
+	if _, _, err := dagParams(driveopts.MergeDAGOptions(d.dag, opt.DAG)); err != nil {
+		return Upload{}, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Upload{}, err
+	}
+
+	s := &uploadState{
+		ID:        id,
+		Key:       key,
+		Options:   opt,
+		Parts:     make(map[int]Part),
+		CreatedAt: time.Now().UTC().Format(time.RFC1123),
+	}
+
+	d.uploadMu.Lock()
+	defer d.uploadMu.Unlock()
+
+	if err := d.saveUpload(s); err != nil {
+		return Upload{}, err
+	}
+	return s.upload(), nil
+}
+
+func (d *drive) UploadPart(ctx context.Context, id string, n int, r io.Reader) (Part, error) {
+	if d.readOnly {
+		return Part{}, ErrReadOnly
+	}
+	if n < 1 || n > maxUploadParts {
+		return Part{}, fmt.Errorf("part number must be between 1 and %d", maxUploadParts)
+	}
+	if r == nil {
+		return Part{}, fmt.Errorf("input stream is a nil pointer")
+	}
+
+	s, err := d.loadUpload(id)
+	if err != nil {
+		return Part{}, err
+	}
+	if err := d.checkWrite(s.Key); err != nil {
+		return Part{}, err
+	}
+	if err := d.precheckQuota(ctx, s.Key, s.size(n)); err != nil {
+		return Part{}, err
+	}
+
+	_, unixfsOpts, err := dagParams(driveopts.MergeDAGOptions(d.dag, s.Options.DAG))
+	if err != nil {
+		return Part{}, err
+	}
+	unixfsOpts = append(unixfsOpts, options.Unixfs.Pin(false))
+
+	node := newFile(s.Key, r)
+	resolve, err := d.api.Unixfs().Add(ctx, node, unixfsOpts...)
+	if err != nil {
+		return Part{}, err
+	}
+	if err := d.pin(ctx, resolve.Cid(), fmt.Sprintf("%s (part %d)", s.Key, n)); err != nil {
+		return Part{}, err
+	}
+
+	p := Part{
+		Number: n,
+		Cid:    resolve.Cid(),
+	}
+
+	p.Size, err = d.fileSize(ctx, resolve)
+	if err != nil {
+		d.unpinParts(ctx, []Part{p})
+		return Part{}, err
+	}
+
+	d.uploadMu.Lock()
+	defer d.uploadMu.Unlock()
+
+	// Reload the state, since other parts might be uploaded meanwhile.
+	s, err = d.loadUpload(id)
+	if err != nil {
+		d.unpinParts(ctx, []Part{p})
+		return Part{}, err
+	}
+
+	// Reject the part once the parts uploaded so far exceed the quota, rather
+	// than when the upload is completed.
+	if err := d.precheckQuota(ctx, s.Key, s.size(n)+p.Size); err != nil {
+		d.unpinParts(ctx, []Part{p})
+		return Part{}, err
+	}
+
+	old, replaced := s.Parts[n]
+	s.Parts[n] = p
+	if err := d.saveUpload(s); err != nil {
+		d.unpinParts(ctx, []Part{p})
+		return Part{}, err
+	}
+
+	if replaced && !old.Cid.Equals(p.Cid) {
+		d.unpinParts(ctx, []Part{old})
+	}
+
+	return p, nil
+}
+
+func (d *drive) CompleteUpload(ctx context.Context, id string) (File, error) {
+	if d.readOnly {
+		return File{}, ErrReadOnly
+	}
+
+	d.uploadMu.Lock()
+	defer d.uploadMu.Unlock()
+
+	s, err := d.loadUpload(id)
+	if err != nil {
+		return File{}, err
+	}
+	if err := d.checkWrite(s.Key); err != nil {
+		return File{}, err
+	}
+
+	parts := s.upload().Parts
+	if len(parts) == 0 {
+		return File{}, fmt.Errorf("upload %q has no parts", id)
+	}
+
+	opt := s.Options
+	params, _, err := dagParams(driveopts.MergeDAGOptions(d.dag, opt.DAG))
+	if err != nil {
+		return File{}, err
+	}
+
+	root, size, err := d.stitchParts(ctx, parts, params)
+	if err != nil {
+		return File{}, err
+	}
+	if opt.ExpectedSize != nil && *opt.ExpectedSize != size {
+		return File{}, &SizeError{Key: s.Key, Expected: *opt.ExpectedSize, Actual: size}
+	}
+	if len(parts) > 1 {
+		if err := d.pin(ctx, root, s.Key); err != nil {
+			return File{}, err
+		}
+		params.Parts = len(parts)
+	}
+
+	now := time.Now()
+	mtime := now
+	if opt.ModTime != nil {
+		mtime = *opt.ModTime
+	}
+
+	f := File{
+		Key:        s.Key,
+		Cid:        root,
+		Size:       size,
+		Timestamp:  now.UTC().Format(time.RFC1123),
+		Owner:      d.Identity(),
+		ModTime:    mtime.UTC().Format(time.RFC1123),
+		StoredSize: size,
+		DAG:        params,
+	}
+	if opt.ExpiresAt != nil {
+		f.ExpiresAt = opt.ExpiresAt.UTC().Format(time.RFC1123)
+	}
+
+	if err := d.sign(ctx, &f); err != nil {
+		return File{}, err
+	}
+
+	// The parts stay referenced by the upload if the file is rejected, so that
+	// the upload can be completed again or aborted.
+	if err := d.commit(ctx, f, opt); err != nil {
+		d.discard(ctx, f)
+		return File{}, err
+	}
+
+	if err := os.Remove(d.uploadPath(id)); err != nil {
+		d.logger.Warn("failed to remove state of completed upload", zap.String("id", id), zap.Error(err))
+	}
+
+	// The stitched file pins the content of the parts from now on.
+	if len(parts) > 1 {
+		d.unpinParts(ctx, parts)
+	}
+
+	return f, nil
+}
+
+func (d *drive) AbortUpload(ctx context.Context, id string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.uploadMu.Lock()
+	defer d.uploadMu.Unlock()
+
+	s, err := d.loadUpload(id)
+	if err != nil {
+		return err
+	}
+	if err := d.checkWrite(s.Key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(d.uploadPath(id)); err != nil {
+		return err
+	}
+
+	d.unpinParts(ctx, s.upload().Parts)
+	return nil
+}
+
+func (d *drive) ListUploads(ctx context.Context) ([]Upload, error) {
+	states, err := d.loadUploads()
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]Upload, 0, len(states))
+	for _, s := range states {
+		uploads = append(uploads, s.upload())
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].ID < uploads[j].ID
+	})
+
+	return uploads, nil
+}
+
+// stitchParts returns the root of a file DAG holding the content of the parts in
+// order, along with its size. The DAG of a single part is returned as is.
+func (d *drive) stitchParts(ctx context.Context, parts []Part, params DAGParams) (cid.Cid, int64, error) {
+	if len(parts) == 1 {
+		return parts[0].Cid, parts[0].Size, nil
+	}
+
+	fsn := ft.NewFSNode(ft.TFile)
+	var size int64
+	for _, p := range parts {
+		fsn.AddBlockSize(uint64(p.Size))
+		size += p.Size
+	}
+
+	data, err := fsn.GetBytes()
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	node := dag.NodeWithData(data)
+	err = node.SetCidBuilder(cid.Prefix{
+		Version:  uint64(params.CidVersion),
+		Codec:    cid.DagProtobuf,
+		MhType:   mh.Names[params.Hash],
+		MhLength: -1,
+	})
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	dagService := d.api.Dag()
+	for _, p := range parts {
+		child, err := dagService.Get(ctx, p.Cid)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		if err := node.AddNodeLink("", child); err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+
+	if err := dagService.Add(ctx, node); err != nil {
+		return cid.Undef, 0, err
+	}
+	return node.Cid(), size, nil
+}
+
+// unpinParts unpins the content of the parts unless the drive refers to it.
+func (d *drive) unpinParts(ctx context.Context, parts []Part) {
+	cids := make([]cid.Cid, len(parts))
+	for i, p := range parts {
+		cids[i] = p.Cid
+	}
+
+	if err := d.releaseAll(ctx, cids); err != nil {
+		d.logger.Warn("failed to unpin parts of upload", zap.Error(err))
+	}
+}
+
+// uploadRefs returns the cids of the parts of pending uploads.
+func (d *drive) uploadRefs() ([]cid.Cid, error) {
+	states, err := d.loadUploads()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []cid.Cid
+	for _, s := range states {
+		for _, p := range s.Parts {
+			refs = append(refs, p.Cid)
+		}
+	}
+	return refs, nil
+}
+
+func (d *drive) uploadPath(id string) string {
+	return filepath.Join(d.uploadDir, id+".json")
+}
+
+func (d *drive) loadUpload(id string) (*uploadState, error) {
+	if len(d.uploadDir) == 0 || len(id) == 0 || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrNoSuchUpload
+	}
+
+	data, err := ioutil.ReadFile(d.uploadPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s uploadState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Options == nil {
+		s.Options = driveopts.Add()
+	}
+	if s.Parts == nil {
+		s.Parts = make(map[int]Part)
+	}
+	return &s, nil
+}
+
+func (d *drive) loadUploads() ([]*uploadState, error) {
+	if len(d.uploadDir) == 0 {
+		return nil, nil
+	}
+
+	infos, err := ioutil.ReadDir(d.uploadDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var states []*uploadState
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		s, err := d.loadUpload(strings.TrimSuffix(info.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// saveUpload saves the state of the upload, replacing the previous one at once.
+func (d *drive) saveUpload(s *uploadState) error {
+	if len(d.uploadDir) == 0 {
+		return fmt.Errorf("uploads are not supported by the drive instance")
+	}
+	if err := os.MkdirAll(d.uploadDir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	fpath := d.uploadPath(s.ID)
+	tmp := fpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fpath)
+}
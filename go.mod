@@ -3,17 +3,27 @@ module github.com/meowdada/ipfstor
 go 1.15
 
 require (
+	berty.tech/go-ipfs-log v1.2.6
 	berty.tech/go-orbit-db v1.10.10
 	github.com/dustin/go-humanize v1.0.0
 	github.com/ipfs/go-cid v0.0.7
 	github.com/ipfs/go-ipfs v0.6.0
+	github.com/ipfs/go-block-format v0.0.2
 	github.com/ipfs/go-ipfs-files v0.0.8
 	github.com/ipfs/go-ipfs-http-client v0.1.0
+	github.com/ipfs/go-ipld-cbor v0.0.4
+	github.com/ipfs/go-ipld-format v0.2.0
+	github.com/ipfs/go-merkledag v0.3.2
+	github.com/ipfs/go-unixfs v0.2.4
 	github.com/ipfs/interface-go-ipfs-core v0.4.0
 	github.com/ipfs/ipfs-cluster v0.13.0
+	github.com/klauspost/compress v1.11.3
 	github.com/libp2p/go-libp2p v0.10.2
+	github.com/libp2p/go-libp2p-core v0.6.1
 	github.com/multiformats/go-multiaddr v0.3.1
+	github.com/multiformats/go-multihash v0.0.14
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.6.1
 	go.uber.org/zap v1.16.0
+	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae
 )
@@ -0,0 +1,275 @@
+// Package keystore manages the keys of orbitdb identities in a local directory,
+// so that multiple identities can operate on a single node. A Keystore can be
+// passed to orbitdb as the keystore of its identities.
+package keystore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"berty.tech/go-ipfs-log/keystore"
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/pkg/errors"
+)
+
+const keyExt = ".key"
+
+var (
+	// ErrNoSuchIdentity denotes an error that indicates no such identity presents.
+	ErrNoSuchIdentity = errors.New("no such identity")
+
+	// ErrIdentityExists denotes an error that indicates an identity with the same
+	// name presents.
+	ErrIdentityExists = errors.New("identity already exists")
+)
+
+var _ keystore.Interface = (*Keystore)(nil)
+
+// Identity denotes an identity whose key is kept in a keystore. ID is the id of
+// the identity used by drives, such as the one granted permissions.
+type Identity struct {
+	Name string
+	ID   string
+}
+
+// Keystore keeps each key as a file in a directory.
+type Keystore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// New opens the keystore in given directory, which is created if it does not
+// exist.
+func New(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir}, nil
+}
+
+// HasKey reports whether the key with given id presents.
+func (ks *Keystore) HasKey(id string) (bool, error) {
+	fpath, err := ks.path(id)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(fpath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateKey creates a key with given id, or returns the existing one.
+func (ks *Keystore) CreateKey(id string) (crypto.PrivKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, err := ks.getKey(id); err == nil {
+		return key, nil
+	} else if err != ErrNoSuchIdentity {
+		return nil, err
+	}
+
+	key, _, err := crypto.GenerateKeyPair(crypto.Secp256k1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.putKey(id, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetKey returns the key with given id.
+func (ks *Keystore) GetKey(id string) (crypto.PrivKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.getKey(id)
+}
+
+// Sign signs the bytes with the key.
+func (ks *Keystore) Sign(key crypto.PrivKey, bytes []byte) ([]byte, error) {
+	return key.Sign(bytes)
+}
+
+// Verify verifies the signature of data against the public key.
+func (ks *Keystore) Verify(signature []byte, publicKey crypto.PubKey, data []byte) error {
+	ok, err := publicKey.Verify(data, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// Create creates an identity with given name.
+func (ks *Keystore) Create(name string) (Identity, error) {
+	ok, err := ks.HasKey(name)
+	if err != nil {
+		return Identity{}, err
+	}
+	if ok {
+		return Identity{}, ErrIdentityExists
+	}
+
+	key, err := ks.CreateKey(name)
+	if err != nil {
+		return Identity{}, err
+	}
+	return newIdentity(name, key)
+}
+
+// Import imports the key exported by Export as an identity with given name.
+func (ks *Keystore) Import(name string, data []byte) (Identity, error) {
+	key, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, err := ks.getKey(name); err == nil {
+		return Identity{}, ErrIdentityExists
+	} else if err != ErrNoSuchIdentity {
+		return Identity{}, err
+	}
+
+	if err := ks.putKey(name, key); err != nil {
+		return Identity{}, err
+	}
+	return newIdentity(name, key)
+}
+
+// Export exports the private key of the identity with given name. The output
+// must be kept secret, since anyone holding it can act as the identity.
+func (ks *Keystore) Export(name string) ([]byte, error) {
+	key, err := ks.GetKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MarshalPrivateKey(key)
+}
+
+// Remove removes the identity with given name.
+func (ks *Keystore) Remove(name string) error {
+	fpath, err := ks.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(fpath)
+	if os.IsNotExist(err) {
+		return ErrNoSuchIdentity
+	}
+	return err
+}
+
+// List lists all identities in the keystore sorted by name. Keys which orbitdb
+// derives from identities to sign entries are not listed.
+func (ks *Keystore) List() ([]Identity, error) {
+	infos, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []Identity
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), keyExt) {
+			continue
+		}
+
+		name := strings.TrimSuffix(info.Name(), keyExt)
+		key, err := ks.GetKey(name)
+		if err != nil {
+			return nil, err
+		}
+		id, err := newIdentity(name, key)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+
+	// A derived key is named after the id of its identity.
+	ids := make(map[string]bool, len(identities))
+	for _, id := range identities {
+		ids[id.ID] = true
+	}
+
+	ret := identities[:0]
+	for _, id := range identities {
+		if !ids[id.Name] {
+			ret = append(ret, id)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret, nil
+}
+
+func (ks *Keystore) getKey(id string) (crypto.PrivKey, error) {
+	fpath, err := ks.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(fpath)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+func (ks *Keystore) putKey(id string, key crypto.PrivKey) error {
+	fpath, err := ks.path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a key is never left half written.
+	tmp := fpath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fpath)
+}
+
+func (ks *Keystore) path(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid key id %q", id)
+	}
+	return filepath.Join(ks.dir, id+keyExt), nil
+}
+
+// newIdentity returns the identity of the key, whose id is derived in the same
+// way as the orbitdb identity provider.
+func newIdentity(name string, key crypto.PrivKey) (Identity, error) {
+	raw, err := key.GetPublic().Raw()
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Name: name, ID: hex.EncodeToString(raw)}, nil
+}
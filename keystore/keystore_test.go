@@ -0,0 +1,69 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mockKeystore(t *testing.T) (*Keystore, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "keystore")
+	require.NoError(t, err)
+
+	ks, err := New(dir)
+	require.NoError(t, err)
+
+	return ks, func() { os.RemoveAll(dir) }
+}
+
+func TestKeystore(t *testing.T) {
+	ks, cleanup := mockKeystore(t)
+	defer cleanup()
+
+	alice, err := ks.Create("alice")
+	require.NoError(t, err)
+	require.NotEmpty(t, alice.ID)
+
+	_, err = ks.Create("alice")
+	require.Equal(t, ErrIdentityExists, err)
+
+	// Keys derived by orbitdb are named after the identity id.
+	_, err = ks.CreateKey(alice.ID)
+	require.NoError(t, err)
+
+	bob, err := ks.Create("bob")
+	require.NoError(t, err)
+
+	ids, err := ks.List()
+	require.NoError(t, err)
+	require.Equal(t, []Identity{alice, bob}, ids)
+
+	data, err := ks.Export("alice")
+	require.NoError(t, err)
+
+	other, cleanup2 := mockKeystore(t)
+	defer cleanup2()
+
+	imported, err := other.Import("alice", data)
+	require.NoError(t, err)
+	require.Equal(t, alice, imported)
+
+	key, err := other.GetKey("alice")
+	require.NoError(t, err)
+	sig, err := other.Sign(key, []byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, ks.Verify(sig, key.GetPublic(), []byte("data")))
+	require.Error(t, ks.Verify(sig, key.GetPublic(), []byte("other")))
+
+	require.NoError(t, ks.Remove("bob"))
+	require.Equal(t, ErrNoSuchIdentity, ks.Remove("bob"))
+	_, err = ks.GetKey("bob")
+	require.Equal(t, ErrNoSuchIdentity, err)
+
+	_, err = ks.Create("../escape")
+	require.Error(t, err)
+}
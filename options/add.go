@@ -0,0 +1,127 @@
+package options
+
+import (
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// AddOptions configures behaviour while adding a file to a drive.
+type AddOptions struct {
+	IfNotExists  *bool
+	IfMatch      *cid.Cid
+	ModTime      *time.Time
+	ExpiresAt    *time.Time
+	DAG          *DAGOptions
+	Compression  *string
+	ExpectedSize *int64
+	Progress     func(read int64) `json:"-"`
+}
+
+// SetIfNotExists sets the IfNotExists field of the AddOptions. If the flag is set,
+// the file is added only if the key does not present yet.
+func (o *AddOptions) SetIfNotExists(flag bool) *AddOptions {
+	o.IfNotExists = &flag
+	return o
+}
+
+// SetIfMatch sets the IfMatch field of the AddOptions. If it is set, the file is
+// added only if the Cid of the current file equals to the given one.
+func (o *AddOptions) SetIfMatch(c cid.Cid) *AddOptions {
+	o.IfMatch = &c
+	return o
+}
+
+// SetModTime sets the ModTime field of the AddOptions, which is recorded as the
+// modification time of the file.
+func (o *AddOptions) SetModTime(t time.Time) *AddOptions {
+	o.ModTime = &t
+	return o
+}
+
+// SetExpiresAt sets the ExpiresAt field of the AddOptions. The file is removed by
+// the janitor of the drive once the time is passed.
+func (o *AddOptions) SetExpiresAt(t time.Time) *AddOptions {
+	o.ExpiresAt = &t
+	return o
+}
+
+// SetTTL sets the ExpiresAt field of the AddOptions to the time after given duration
+// from now.
+func (o *AddOptions) SetTTL(ttl time.Duration) *AddOptions {
+	return o.SetExpiresAt(time.Now().Add(ttl))
+}
+
+// SetDAG sets the DAG field of the AddOptions, which configures how the content is
+// chunked and laid out. Its fields take precedence over the defaults of the drive.
+func (o *AddOptions) SetDAG(dag *DAGOptions) *AddOptions {
+	o.DAG = dag
+	return o
+}
+
+// SetCompression sets the Compression field of the AddOptions, which is the name of
+// the algorithm to compress the content with, such as "gzip" or "zstd". An empty
+// name disables compression, overriding the default of the drive.
+func (o *AddOptions) SetCompression(algorithm string) *AddOptions {
+	o.Compression = &algorithm
+	return o
+}
+
+// SetExpectedSize sets the ExpectedSize field of the AddOptions. If it is set, the
+// file is rejected unless the size of its content equals to it, which is also used
+// to check quotas before pushing the content of a stream.
+func (o *AddOptions) SetExpectedSize(size int64) *AddOptions {
+	o.ExpectedSize = &size
+	return o
+}
+
+// SetProgress sets the Progress field of the AddOptions, which is called with the
+// number of bytes read from the content so far while it is being added. It might
+// be called from another goroutine.
+func (o *AddOptions) SetProgress(fn func(read int64)) *AddOptions {
+	o.Progress = fn
+	return o
+}
+
+// Add creates a new AddOptions instance.
+func Add() *AddOptions {
+	return &AddOptions{}
+}
+
+// MergeAddOptions combines given AddOptions into a single AddOptions in
+// a last-one-wins fashion.
+func MergeAddOptions(opts ...*AddOptions) *AddOptions {
+	o := Add()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.IfNotExists != nil {
+			o.IfNotExists = opt.IfNotExists
+		}
+		if opt.IfMatch != nil {
+			o.IfMatch = opt.IfMatch
+		}
+		if opt.ModTime != nil {
+			o.ModTime = opt.ModTime
+		}
+		if opt.ExpiresAt != nil {
+			o.ExpiresAt = opt.ExpiresAt
+		}
+		if opt.DAG != nil {
+			o.DAG = MergeDAGOptions(o.DAG, opt.DAG)
+		}
+		if opt.Compression != nil {
+			o.Compression = opt.Compression
+		}
+		if opt.ExpectedSize != nil {
+			o.ExpectedSize = opt.ExpectedSize
+		}
+		if opt.Progress != nil {
+			o.Progress = opt.Progress
+		}
+	}
+
+	return o
+}
@@ -0,0 +1,40 @@
+package options
+
+// BatchOptions configures behaviour of batch operations on a drive.
+type BatchOptions struct {
+	Workers *int
+}
+
+// SetWorkers sets the Workers field of the BatchOptions, which limits the number of
+// items being processed concurrently. If the input value is not positive, the field
+// will be set to nil.
+func (o *BatchOptions) SetWorkers(n int) *BatchOptions {
+	if n <= 0 {
+		o.Workers = nil
+		return o
+	}
+	o.Workers = &n
+	return o
+}
+
+// Batch creates a new BatchOptions instance.
+func Batch() *BatchOptions {
+	return &BatchOptions{}
+}
+
+// MergeBatchOptions combines given BatchOptions into a single BatchOptions in
+// a last-one-wins fashion.
+func MergeBatchOptions(opts ...*BatchOptions) *BatchOptions {
+	o := Batch()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Workers != nil {
+			o.Workers = opt.Workers
+		}
+	}
+
+	return o
+}
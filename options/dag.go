@@ -0,0 +1,102 @@
+package options
+
+const (
+	// LayoutBalanced lays out the chunks of content as a balanced tree, which
+	// favors random access.
+	LayoutBalanced = "balanced"
+
+	// LayoutTrickle lays out the chunks of content as a trickle tree, which
+	// favors sequential reads such as streaming.
+	LayoutTrickle = "trickle"
+)
+
+// DAGOptions configures how content is chunked and laid out as a DAG while being
+// added to ipfs. Fields left unset fall back to the defaults of the ipfs node.
+type DAGOptions struct {
+	Chunker    *string
+	Layout     *string
+	RawLeaves  *bool
+	CidVersion *int
+	Hash       *string
+}
+
+// SetChunker sets the Chunker field of the DAGOptions, which is the chunking
+// algorithm understood by ipfs, such as "size-262144", "rabin-16384-65536-131072"
+// or "buzhash". Content defined chunkers dedup shifted content better than fixed
+// size chunks. If the input value is zero-length, the field will be set to nil.
+func (o *DAGOptions) SetChunker(chunker string) *DAGOptions {
+	if len(chunker) == 0 {
+		o.Chunker = nil
+		return o
+	}
+	o.Chunker = &chunker
+	return o
+}
+
+// SetLayout sets the Layout field of the DAGOptions, which is either LayoutBalanced
+// or LayoutTrickle.
+func (o *DAGOptions) SetLayout(layout string) *DAGOptions {
+	o.Layout = &layout
+	return o
+}
+
+// SetRawLeaves sets the RawLeaves field of the DAGOptions. If the flag is set, the
+// chunks are stored as raw blocks instead of being wrapped in UnixFS nodes. It
+// defaults to be set with CID version 1.
+func (o *DAGOptions) SetRawLeaves(flag bool) *DAGOptions {
+	o.RawLeaves = &flag
+	return o
+}
+
+// SetCidVersion sets the CidVersion field of the DAGOptions, which is either 0 or
+// 1. CID version 1 is implied by hash functions other than sha2-256.
+func (o *DAGOptions) SetCidVersion(version int) *DAGOptions {
+	o.CidVersion = &version
+	return o
+}
+
+// SetHash sets the Hash field of the DAGOptions, which is the name of a multihash
+// function, such as "sha2-256" or "blake2b-256". If the input value is zero-length,
+// the field will be set to nil.
+func (o *DAGOptions) SetHash(name string) *DAGOptions {
+	if len(name) == 0 {
+		o.Hash = nil
+		return o
+	}
+	o.Hash = &name
+	return o
+}
+
+// DAG creates a new DAGOptions instance.
+func DAG() *DAGOptions {
+	return &DAGOptions{}
+}
+
+// MergeDAGOptions combines given DAGOptions into a single DAGOptions in a
+// last-one-wins fashion.
+func MergeDAGOptions(opts ...*DAGOptions) *DAGOptions {
+	o := DAG()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Chunker != nil {
+			o.Chunker = opt.Chunker
+		}
+		if opt.Layout != nil {
+			o.Layout = opt.Layout
+		}
+		if opt.RawLeaves != nil {
+			o.RawLeaves = opt.RawLeaves
+		}
+		if opt.CidVersion != nil {
+			o.CidVersion = opt.CidVersion
+		}
+		if opt.Hash != nil {
+			o.Hash = opt.Hash
+		}
+	}
+
+	return o
+}
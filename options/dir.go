@@ -0,0 +1,75 @@
+package options
+
+import (
+	"path"
+)
+
+// SymlinkPolicy determines how symbolic links are treated while walking a
+// local directory.
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip ignores symbolic links.
+	SymlinkSkip SymlinkPolicy = iota
+
+	// SymlinkFollow follows symbolic links and adds their targets.
+	SymlinkFollow
+
+	// SymlinkReject aborts the walk once a symbolic link is found.
+	SymlinkReject
+)
+
+// AddDirOptions configures behaviour while adding a local directory to a drive.
+type AddDirOptions struct {
+	Ignore   []string
+	Symlinks *SymlinkPolicy
+}
+
+// SetIgnore sets the Ignore field of the AddDirOptions. Each pattern follows the
+// syntax of path.Match and is matched against both the base name and the slash
+// separated path relative to the directory being added.
+func (o *AddDirOptions) SetIgnore(patterns ...string) *AddDirOptions {
+	o.Ignore = patterns
+	return o
+}
+
+// SetSymlinks sets the Symlinks field of the AddDirOptions.
+func (o *AddDirOptions) SetSymlinks(policy SymlinkPolicy) *AddDirOptions {
+	o.Symlinks = &policy
+	return o
+}
+
+// Validate checks whether the ignore patterns are well-formed.
+func (o *AddDirOptions) Validate() error {
+	for _, pattern := range o.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDir creates a new AddDirOptions instance.
+func AddDir() *AddDirOptions {
+	return &AddDirOptions{}
+}
+
+// MergeAddDirOptions combines given AddDirOptions into a single AddDirOptions in
+// a last-one-wins fashion.
+func MergeAddDirOptions(opts ...*AddDirOptions) *AddDirOptions {
+	o := AddDir()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Ignore != nil {
+			o.Ignore = opt.Ignore
+		}
+		if opt.Symlinks != nil {
+			o.Symlinks = opt.Symlinks
+		}
+	}
+
+	return o
+}
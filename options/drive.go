@@ -1,7 +1,11 @@
 package options
 
 import (
+	"time"
+
 	"berty.tech/go-orbit-db/accesscontroller"
+	cluster "github.com/ipfs/ipfs-cluster/api/rest/client"
+	"github.com/meowdada/ipfstor/pinning"
 	"go.uber.org/zap"
 )
 
@@ -11,6 +15,25 @@ type OpenDriveOptions struct {
 	Logger           *zap.Logger
 	AccessController accesscontroller.ManifestParams
 	Create           *bool
+	Trash            *bool
+	JanitorInterval  *time.Duration
+	Quota            *Quota
+	OwnerQuotas      map[string]Quota
+	Cluster          cluster.Client
+	ReplicationMin   *int
+	ReplicationMax   *int
+	Pinning          pinning.Backend
+	PathScoped       *bool
+	Keystore         *string
+	Identity         *string
+	DAG              *DAGOptions
+	Compression      *string
+}
+
+// Quota denotes the limits of storage usage. A zero field means no limit.
+type Quota struct {
+	Bytes int64
+	Files int64
 }
 
 // SetDirectory sets the Directory field of the OpenDriveOptions. If the input value
@@ -42,6 +65,115 @@ func (o *OpenDriveOptions) SetCreate(flag bool) *OpenDriveOptions {
 	return o
 }
 
+// SetTrash sets the Trash field of the OpenDriveOptions. If the flag is set, removed
+// files are moved to the trash bin of the drive instead of being unpinned at once.
+func (o *OpenDriveOptions) SetTrash(flag bool) *OpenDriveOptions {
+	o.Trash = &flag
+	return o
+}
+
+// SetJanitorInterval sets the JanitorInterval field of the OpenDriveOptions. If it is
+// positive, a janitor removes expired files from the drive periodically with given
+// interval until the drive is closed.
+func (o *OpenDriveOptions) SetJanitorInterval(interval time.Duration) *OpenDriveOptions {
+	o.JanitorInterval = &interval
+	return o
+}
+
+// SetQuota sets the Quota field of the OpenDriveOptions, which limits the storage
+// usage of the whole drive.
+func (o *OpenDriveOptions) SetQuota(q Quota) *OpenDriveOptions {
+	o.Quota = &q
+	return o
+}
+
+// SetOwnerQuota sets the quota of given owner identity in the OwnerQuotas field of
+// the OpenDriveOptions, which limits the storage usage of files owned by it.
+func (o *OpenDriveOptions) SetOwnerQuota(owner string, q Quota) *OpenDriveOptions {
+	if o.OwnerQuotas == nil {
+		o.OwnerQuotas = make(map[string]Quota)
+	}
+	o.OwnerQuotas[owner] = q
+	return o
+}
+
+// SetCluster sets the Cluster field of the OpenDriveOptions. If it is set, the content
+// of files is pinned through the ipfs-cluster instead of the local ipfs node. The
+// cluster fetches the content from the ipfs network, so the node should be reachable
+// by the cluster peers, or be the ipfs daemon of one of them.
+func (o *OpenDriveOptions) SetCluster(c cluster.Client) *OpenDriveOptions {
+	o.Cluster = c
+	return o
+}
+
+// SetReplication sets the ReplicationMin and ReplicationMax fields of the
+// OpenDriveOptions, which are the replication factors of pins issued to the
+// ipfs-cluster. Zero values fall back to the defaults of the cluster, and -1
+// means pinning on every cluster peer.
+func (o *OpenDriveOptions) SetReplication(min, max int) *OpenDriveOptions {
+	o.ReplicationMin = &min
+	o.ReplicationMax = &max
+	return o
+}
+
+// SetPinning sets the Pinning field of the OpenDriveOptions. If it is set, the content
+// of files is pinned through the backend instead of the local ipfs node, which takes
+// precedence over the Cluster field.
+func (o *OpenDriveOptions) SetPinning(backend pinning.Backend) *OpenDriveOptions {
+	o.Pinning = backend
+	return o
+}
+
+// SetPathScoped sets the PathScoped field of the OpenDriveOptions. If the flag is set
+// while creating a drive without an explicit access controller, the drive is created
+// with an access controller which supports granting write permissions on key
+// prefixes, with the identity of the instance as its admin.
+func (o *OpenDriveOptions) SetPathScoped(flag bool) *OpenDriveOptions {
+	o.PathScoped = &flag
+	return o
+}
+
+// SetKeystore sets the Keystore field of the OpenDriveOptions, which is the directory
+// of the keystore holding the key of the identity. If the input value is zero-length,
+// the field will be set to nil, and the keystore under the drive directory is used.
+func (o *OpenDriveOptions) SetKeystore(dir string) *OpenDriveOptions {
+	if len(dir) == 0 {
+		o.Keystore = nil
+		return o
+	}
+	o.Keystore = &dir
+	return o
+}
+
+// SetIdentity sets the Identity field of the OpenDriveOptions, which is the name of
+// the identity in the keystore to open the drive with. A key is created for the name
+// if it does not present. If the input value is zero-length, the field will be set
+// to nil, and the drive is opened with the identity derived from the ipfs node.
+func (o *OpenDriveOptions) SetIdentity(name string) *OpenDriveOptions {
+	if len(name) == 0 {
+		o.Identity = nil
+		return o
+	}
+	o.Identity = &name
+	return o
+}
+
+// SetDAG sets the DAG field of the OpenDriveOptions, which is the default of how
+// the content of files added to the drive is chunked and laid out.
+func (o *OpenDriveOptions) SetDAG(dag *DAGOptions) *OpenDriveOptions {
+	o.DAG = dag
+	return o
+}
+
+// SetCompression sets the Compression field of the OpenDriveOptions, which is the
+// name of the algorithm to compress the content of files added to the drive with by
+// default, such as "gzip" or "zstd". Content which looks compressed already is
+// stored as is.
+func (o *OpenDriveOptions) SetCompression(algorithm string) *OpenDriveOptions {
+	o.Compression = &algorithm
+	return o
+}
+
 // OpenDrive creates a new OpenDriveOptions instance.
 func OpenDrive() *OpenDriveOptions {
 	return &OpenDriveOptions{}
@@ -68,6 +200,45 @@ func MergeOpenDriveOptions(opts ...*OpenDriveOptions) *OpenDriveOptions {
 		if opt.Create != nil {
 			o.Create = opt.Create
 		}
+		if opt.Trash != nil {
+			o.Trash = opt.Trash
+		}
+		if opt.JanitorInterval != nil {
+			o.JanitorInterval = opt.JanitorInterval
+		}
+		if opt.Quota != nil {
+			o.Quota = opt.Quota
+		}
+		for owner, q := range opt.OwnerQuotas {
+			o.SetOwnerQuota(owner, q)
+		}
+		if opt.Cluster != nil {
+			o.Cluster = opt.Cluster
+		}
+		if opt.ReplicationMin != nil {
+			o.ReplicationMin = opt.ReplicationMin
+		}
+		if opt.ReplicationMax != nil {
+			o.ReplicationMax = opt.ReplicationMax
+		}
+		if opt.Pinning != nil {
+			o.Pinning = opt.Pinning
+		}
+		if opt.PathScoped != nil {
+			o.PathScoped = opt.PathScoped
+		}
+		if opt.Keystore != nil {
+			o.Keystore = opt.Keystore
+		}
+		if opt.Identity != nil {
+			o.Identity = opt.Identity
+		}
+		if opt.DAG != nil {
+			o.DAG = MergeDAGOptions(o.DAG, opt.DAG)
+		}
+		if opt.Compression != nil {
+			o.Compression = opt.Compression
+		}
 	}
 
 	return o
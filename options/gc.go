@@ -0,0 +1,39 @@
+package options
+
+import "context"
+
+// GCOptions configures behaviour of collecting garbage of drives.
+type GCOptions struct {
+	RepoGC func(ctx context.Context) error
+}
+
+// SetRepoGC sets the RepoGC field of the GCOptions. The function is called to run
+// the garbage collection of the ipfs repo after orphaned pins are removed, such as
+// a wrapper of corerepo.GarbageCollect for an embedded node. It is not called for
+// a dry run.
+func (o *GCOptions) SetRepoGC(fn func(ctx context.Context) error) *GCOptions {
+	o.RepoGC = fn
+	return o
+}
+
+// GC creates a new GCOptions instance.
+func GC() *GCOptions {
+	return &GCOptions{}
+}
+
+// MergeGCOptions combines given GCOptions into a single GCOptions in a last-one-wins
+// fashion.
+func MergeGCOptions(opts ...*GCOptions) *GCOptions {
+	o := GC()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.RepoGC != nil {
+			o.RepoGC = opt.RepoGC
+		}
+	}
+
+	return o
+}
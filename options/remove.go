@@ -0,0 +1,50 @@
+package options
+
+import (
+	"github.com/ipfs/go-cid"
+)
+
+// RemoveOptions configures behaviour while removing a file from a drive.
+type RemoveOptions struct {
+	IfMatch   *cid.Cid
+	Permanent *bool
+}
+
+// SetIfMatch sets the IfMatch field of the RemoveOptions. If it is set, the file is
+// removed only if its Cid equals to the given one.
+func (o *RemoveOptions) SetIfMatch(c cid.Cid) *RemoveOptions {
+	o.IfMatch = &c
+	return o
+}
+
+// SetPermanent sets the Permanent field of the RemoveOptions. If the flag is set, the
+// file is unpinned at once even if the trash bin of the drive is enabled.
+func (o *RemoveOptions) SetPermanent(flag bool) *RemoveOptions {
+	o.Permanent = &flag
+	return o
+}
+
+// Remove creates a new RemoveOptions instance.
+func Remove() *RemoveOptions {
+	return &RemoveOptions{}
+}
+
+// MergeRemoveOptions combines given RemoveOptions into a single RemoveOptions in
+// a last-one-wins fashion.
+func MergeRemoveOptions(opts ...*RemoveOptions) *RemoveOptions {
+	o := Remove()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.IfMatch != nil {
+			o.IfMatch = opt.IfMatch
+		}
+		if opt.Permanent != nil {
+			o.Permanent = opt.Permanent
+		}
+	}
+
+	return o
+}
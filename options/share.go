@@ -0,0 +1,56 @@
+package options
+
+import "time"
+
+// ShareOptions configures behaviour while sharing files of a drive.
+type ShareOptions struct {
+	Token     *bool
+	ExpiresAt *time.Time
+}
+
+// SetToken sets the Token field of the ShareOptions. If the flag is set, the share
+// is issued as a capability token signed by the identity of the instance, instead
+// of being published to IPNS.
+func (o *ShareOptions) SetToken(flag bool) *ShareOptions {
+	o.Token = &flag
+	return o
+}
+
+// SetExpiresAt sets the ExpiresAt field of the ShareOptions. The share is revoked
+// by the janitor of the drive once the time is passed, and its token is rejected
+// since then.
+func (o *ShareOptions) SetExpiresAt(t time.Time) *ShareOptions {
+	o.ExpiresAt = &t
+	return o
+}
+
+// SetTTL sets the ExpiresAt field of the ShareOptions to the time after given
+// duration from now.
+func (o *ShareOptions) SetTTL(ttl time.Duration) *ShareOptions {
+	return o.SetExpiresAt(time.Now().Add(ttl))
+}
+
+// Share creates a new ShareOptions instance.
+func Share() *ShareOptions {
+	return &ShareOptions{}
+}
+
+// MergeShareOptions combines given ShareOptions into a single ShareOptions in
+// a last-one-wins fashion.
+func MergeShareOptions(opts ...*ShareOptions) *ShareOptions {
+	o := Share()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Token != nil {
+			o.Token = opt.Token
+		}
+		if opt.ExpiresAt != nil {
+			o.ExpiresAt = opt.ExpiresAt
+		}
+	}
+
+	return o
+}
@@ -0,0 +1,35 @@
+package options
+
+// StatOptions configures behaviour while statting a file of a drive.
+type StatOptions struct {
+	Pins *bool
+}
+
+// SetPins sets the Pins field of the StatOptions. If the flag is set, the status
+// of the pin of the content is queried from the pinning backend of the drive.
+func (o *StatOptions) SetPins(flag bool) *StatOptions {
+	o.Pins = &flag
+	return o
+}
+
+// Stat creates a new StatOptions instance.
+func Stat() *StatOptions {
+	return &StatOptions{}
+}
+
+// MergeStatOptions combines given StatOptions into a single StatOptions in
+// a last-one-wins fashion.
+func MergeStatOptions(opts ...*StatOptions) *StatOptions {
+	o := Stat()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Pins != nil {
+			o.Pins = opt.Pins
+		}
+	}
+
+	return o
+}
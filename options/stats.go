@@ -0,0 +1,56 @@
+package options
+
+// StatsOptions configures behaviour of collecting statistics of a drive.
+type StatsOptions struct {
+	PrefixDepth *int
+	Largest     *int
+}
+
+// SetPrefixDepth sets the PrefixDepth field of the StatsOptions, which determines
+// how many leading path segments of keys are used to group files by prefix. If the
+// input value is not positive, the field will be set to nil.
+func (o *StatsOptions) SetPrefixDepth(depth int) *StatsOptions {
+	if depth <= 0 {
+		o.PrefixDepth = nil
+		return o
+	}
+	o.PrefixDepth = &depth
+	return o
+}
+
+// SetLargest sets the Largest field of the StatsOptions, which limits the number of
+// the largest files being reported. If the input value is negative, the field will
+// be set to nil.
+func (o *StatsOptions) SetLargest(n int) *StatsOptions {
+	if n < 0 {
+		o.Largest = nil
+		return o
+	}
+	o.Largest = &n
+	return o
+}
+
+// Stats creates a new StatsOptions instance.
+func Stats() *StatsOptions {
+	return &StatsOptions{}
+}
+
+// MergeStatsOptions combines given StatsOptions into a single StatsOptions in
+// a last-one-wins fashion.
+func MergeStatsOptions(opts ...*StatsOptions) *StatsOptions {
+	o := Stats()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.PrefixDepth != nil {
+			o.PrefixDepth = opt.PrefixDepth
+		}
+		if opt.Largest != nil {
+			o.Largest = opt.Largest
+		}
+	}
+
+	return o
+}
@@ -0,0 +1,105 @@
+package options
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ConflictPolicy determines how a file changed on both sides is resolved while
+// synchronizing a local directory with a drive.
+type ConflictPolicy int
+
+const (
+	// ConflictKeepBoth renames the local file to a conflict copy, uploads the copy
+	// and downloads the remote file to the original path.
+	ConflictKeepBoth ConflictPolicy = iota
+
+	// ConflictPreferLocal overwrites the remote file with the local one.
+	ConflictPreferLocal
+
+	// ConflictPreferRemote overwrites the local file with the remote one.
+	ConflictPreferRemote
+)
+
+// SyncOptions configures behaviour of synchronizing a local directory with a drive.
+type SyncOptions struct {
+	Interval  *time.Duration
+	Conflict  *ConflictPolicy
+	StatePath *string
+	Ignore    []string
+	Logger    *zap.Logger
+}
+
+// SetInterval sets the Interval field of the SyncOptions, which determines how often
+// the drive is polled for remote changes.
+func (o *SyncOptions) SetInterval(interval time.Duration) *SyncOptions {
+	o.Interval = &interval
+	return o
+}
+
+// SetConflict sets the Conflict field of the SyncOptions.
+func (o *SyncOptions) SetConflict(policy ConflictPolicy) *SyncOptions {
+	o.Conflict = &policy
+	return o
+}
+
+// SetStatePath sets the StatePath field of the SyncOptions, which locates the state
+// database recording the last synchronized version of each file. If the input value
+// is zero-length, the field will be set to nil.
+func (o *SyncOptions) SetStatePath(fpath string) *SyncOptions {
+	if len(fpath) == 0 {
+		o.StatePath = nil
+		return o
+	}
+	o.StatePath = &fpath
+	return o
+}
+
+// SetIgnore sets the Ignore field of the SyncOptions. The patterns follow the same
+// rules as AddDirOptions.
+func (o *SyncOptions) SetIgnore(patterns ...string) *SyncOptions {
+	o.Ignore = patterns
+	return o
+}
+
+// SetLogger sets the Logger field of the SyncOptions, which reports the passes
+// failed while running continuously.
+func (o *SyncOptions) SetLogger(logger *zap.Logger) *SyncOptions {
+	o.Logger = logger
+	return o
+}
+
+// Sync creates a new SyncOptions instance.
+func Sync() *SyncOptions {
+	return &SyncOptions{}
+}
+
+// MergeSyncOptions combines given SyncOptions into a single SyncOptions in
+// a last-one-wins fashion.
+func MergeSyncOptions(opts ...*SyncOptions) *SyncOptions {
+	o := Sync()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Interval != nil {
+			o.Interval = opt.Interval
+		}
+		if opt.Conflict != nil {
+			o.Conflict = opt.Conflict
+		}
+		if opt.StatePath != nil {
+			o.StatePath = opt.StatePath
+		}
+		if opt.Ignore != nil {
+			o.Ignore = opt.Ignore
+		}
+		if opt.Logger != nil {
+			o.Logger = opt.Logger
+		}
+	}
+
+	return o
+}
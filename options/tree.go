@@ -0,0 +1,41 @@
+package options
+
+// PublishTreeOptions configures behaviour while publishing a directory tree of a
+// drive.
+type PublishTreeOptions struct {
+	Key *string
+}
+
+// SetKey sets the Key field of the PublishTreeOptions. If it is set, the tree is
+// published to IPNS with the ipfs key of given name, such as "self" for the key
+// of the node. If the input value is zero-length, the field will be set to nil.
+func (o *PublishTreeOptions) SetKey(name string) *PublishTreeOptions {
+	if len(name) == 0 {
+		o.Key = nil
+		return o
+	}
+	o.Key = &name
+	return o
+}
+
+// PublishTree creates a new PublishTreeOptions instance.
+func PublishTree() *PublishTreeOptions {
+	return &PublishTreeOptions{}
+}
+
+// MergePublishTreeOptions combines given PublishTreeOptions into a single
+// PublishTreeOptions in a last-one-wins fashion.
+func MergePublishTreeOptions(opts ...*PublishTreeOptions) *PublishTreeOptions {
+	o := PublishTree()
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Key != nil {
+			o.Key = opt.Key
+		}
+	}
+
+	return o
+}
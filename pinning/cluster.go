@@ -0,0 +1,68 @@
+package pinning
+
+import (
+	"context"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/api/rest/client"
+)
+
+// Cluster pins content through an ipfs-cluster.
+type Cluster struct {
+	client client.Client
+	min    int
+	max    int
+}
+
+// NewCluster creates a backend pinning content through the ipfs-cluster with given
+// replication factors. Zero values fall back to the defaults of the cluster, and -1
+// means pinning on every cluster peer.
+func NewCluster(c client.Client, min, max int) *Cluster {
+	return &Cluster{
+		client: c,
+		min:    min,
+		max:    max,
+	}
+}
+
+// Pin implements Backend interface.
+func (cl *Cluster) Pin(ctx context.Context, c cid.Cid, name string) error {
+	_, err := cl.client.Pin(ctx, c, api.PinOptions{
+		ReplicationFactorMin: cl.min,
+		ReplicationFactorMax: cl.max,
+		Name:                 name,
+	})
+	return err
+}
+
+// Unpin implements Backend interface.
+func (cl *Cluster) Unpin(ctx context.Context, c cid.Cid) error {
+	_, err := cl.client.Unpin(ctx, c)
+	return err
+}
+
+// Status implements Backend interface. It reports the status on each cluster peer,
+// sorted by peer.
+func (cl *Cluster) Status(ctx context.Context, c cid.Cid) ([]Status, error) {
+	info, err := cl.client.Status(ctx, c, false)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(info.PeerMap))
+	for peer, pi := range info.PeerMap {
+		statuses = append(statuses, Status{
+			Peer:     peer,
+			PeerName: pi.PeerName,
+			Status:   pi.Status.String(),
+			Error:    pi.Error,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Peer < statuses[j].Peer
+	})
+
+	return statuses, nil
+}
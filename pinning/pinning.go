@@ -0,0 +1,88 @@
+// Package pinning provides backends which place the pins of content added to
+// drives, such as the local ipfs node, an ipfs-cluster or a remote pinning
+// service.
+package pinning
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// StatusPinned denotes the content is pinned.
+	StatusPinned = "pinned"
+
+	// StatusUnpinned denotes the content is not pinned.
+	StatusUnpinned = "unpinned"
+)
+
+// Backend denotes a place where content is pinned.
+type Backend interface {
+	// Pin pins the content of given cid recursively. The name labels the pin
+	// if the backend supports it.
+	Pin(ctx context.Context, c cid.Cid, name string) error
+
+	// Unpin removes the pin of given cid. It does nothing if the content is
+	// not pinned.
+	Unpin(ctx context.Context, c cid.Cid) error
+
+	// Status reports the status of the pin of given cid at each place holding
+	// the content.
+	Status(ctx context.Context, c cid.Cid) ([]Status, error)
+}
+
+// Status denotes the status of a pin at a single place, such as a cluster peer
+// or a pin request of a pinning service.
+type Status struct {
+	Peer     string
+	PeerName string
+	Status   string
+	Error    string
+}
+
+// Local pins content through the pin api of an ipfs node.
+type Local struct {
+	pin coreiface.PinAPI
+}
+
+// NewLocal creates a backend pinning content through given pin api.
+func NewLocal(pin coreiface.PinAPI) *Local {
+	return &Local{pin: pin}
+}
+
+// Pin implements Backend interface.
+func (l *Local) Pin(ctx context.Context, c cid.Cid, name string) error {
+	return l.pin.Add(ctx, path.IpfsPath(c))
+}
+
+// Unpin implements Backend interface.
+func (l *Local) Unpin(ctx context.Context, c cid.Cid) error {
+	_, ok, err := l.pin.IsPinned(ctx, path.IpfsPath(c))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	return l.pin.Rm(ctx, path.IpfsPath(c), options.Pin.RmRecursive(true))
+}
+
+// Status implements Backend interface.
+func (l *Local) Status(ctx context.Context, c cid.Cid) ([]Status, error) {
+	_, ok, err := l.pin.IsPinned(ctx, path.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+
+	status := StatusUnpinned
+	if ok {
+		status = StatusPinned
+	}
+
+	return []Status{{Peer: "local", Status: status}}, nil
+}
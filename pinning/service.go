@@ -0,0 +1,187 @@
+package pinning
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// pinStatuses is the query of pin requests in every state, since the pinning
+// service only lists pinned ones by default.
+const pinStatuses = "queued,pinning,pinned,failed"
+
+// Service pins content through a remote pinning service implementing the IPFS
+// Pinning Service API.
+type Service struct {
+	endpoint *url.URL
+	token    string
+	origins  []string
+
+	// Client is the http client used to issue requests, which is
+	// http.DefaultClient by default.
+	Client *http.Client
+}
+
+// NewService creates a backend pinning content through the pinning service at
+// given endpoint, which authorizes requests by the access token. The origins are
+// multiaddrs of peers providing the content, which are passed to the service to
+// fetch the content faster.
+func NewService(endpoint, token string, origins ...string) (*Service, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported pinning service endpoint %q", endpoint)
+	}
+
+	return &Service{
+		endpoint: u,
+		token:    token,
+		origins:  origins,
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+type servicePin struct {
+	Cid     string   `json:"cid"`
+	Name    string   `json:"name,omitempty"`
+	Origins []string `json:"origins,omitempty"`
+}
+
+type servicePinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Pin       servicePin        `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info"`
+}
+
+type servicePinResults struct {
+	Count   int                `json:"count"`
+	Results []servicePinStatus `json:"results"`
+}
+
+type serviceFailure struct {
+	Error struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	} `json:"error"`
+}
+
+// Pin implements Backend interface. The pin request is accepted by the service
+// before the content is actually pinned, whose progress is reported by Status.
+func (s *Service) Pin(ctx context.Context, c cid.Cid, name string) error {
+	pin := servicePin{
+		Cid:     c.String(),
+		Name:    name,
+		Origins: s.origins,
+	}
+
+	return s.do(ctx, http.MethodPost, "/pins", nil, pin, nil)
+}
+
+// Unpin implements Backend interface. It removes every pin request of given cid.
+func (s *Service) Unpin(ctx context.Context, c cid.Cid) error {
+	pins, err := s.list(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pins {
+		if err := s.do(ctx, http.MethodDelete, "/pins/"+url.PathEscape(p.RequestID), nil, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status implements Backend interface. It reports the status of each pin request
+// of given cid, where Peer is the id of the request.
+func (s *Service) Status(ctx context.Context, c cid.Cid) ([]Status, error) {
+	pins, err := s.list(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(pins))
+	for i, p := range pins {
+		statuses[i] = Status{
+			Peer:     p.RequestID,
+			PeerName: s.endpoint.Host,
+			Status:   p.Status,
+			Error:    p.Info["error"],
+		}
+	}
+
+	return statuses, nil
+}
+
+// list lists all pin requests of given cid.
+func (s *Service) list(ctx context.Context, c cid.Cid) ([]servicePinStatus, error) {
+	query := url.Values{}
+	query.Set("cid", c.String())
+	query.Set("status", pinStatuses)
+
+	var results servicePinResults
+	if err := s.do(ctx, http.MethodGet, "/pins", query, nil, &results); err != nil {
+		return nil, err
+	}
+
+	return results.Results, nil
+}
+
+// do issues a request to the pinning service. The body is encoded in json if it
+// is not nil, and the response is decoded into out if it is not nil.
+func (s *Service) do(ctx context.Context, method, p string, query url.Values, body, out interface{}) error {
+	u := *s.endpoint
+	u.Path += p
+	u.RawQuery = query.Encode()
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var failure serviceFailure
+		data, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &failure); err == nil && len(failure.Error.Reason) != 0 {
+			return fmt.Errorf("pinning service: %s: %s", failure.Error.Reason, failure.Error.Details)
+		}
+		return fmt.Errorf("pinning service: unexpected status %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,110 @@
+package pinning
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+const mockToken = "secret"
+
+// mockService serves the subset of the Pinning Service API used by Service.
+type mockService struct {
+	mu   sync.Mutex
+	next int
+	pins map[string]servicePinStatus
+}
+
+func (m *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+mockToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{"reason": "UNAUTHORIZED", "details": "invalid token"},
+		})
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/pins":
+		var pin servicePin
+		if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.next++
+		ps := servicePinStatus{
+			RequestID: strconv.Itoa(m.next),
+			Status:    "queued",
+			Pin:       pin,
+		}
+		m.pins[ps.RequestID] = ps
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ps)
+	case r.Method == http.MethodGet && r.URL.Path == "/pins":
+		results := servicePinResults{}
+		for _, ps := range m.pins {
+			if ps.Pin.Cid == r.URL.Query().Get("cid") {
+				results.Results = append(results.Results, ps)
+			}
+		}
+		results.Count = len(results.Results)
+		json.NewEncoder(w).Encode(results)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/pins/"):
+		delete(m.pins, strings.TrimPrefix(r.URL.Path, "/pins/"))
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &mockService{pins: make(map[string]servicePinStatus)}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	c, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	require.NoError(t, err)
+
+	s, err := NewService(srv.URL+"/", mockToken)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Pin(ctx, c, "a"))
+	require.Len(t, m.pins, 1)
+	require.Equal(t, "a", m.pins["1"].Pin.Name)
+
+	statuses, err := s.Status(ctx, c)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "1", statuses[0].Peer)
+	require.Equal(t, "queued", statuses[0].Status)
+
+	require.NoError(t, s.Unpin(ctx, c))
+	require.Empty(t, m.pins)
+
+	statuses, err = s.Status(ctx, c)
+	require.NoError(t, err)
+	require.Empty(t, statuses)
+
+	s, err = NewService(srv.URL, "invalid")
+	require.NoError(t, err)
+	err = s.Pin(ctx, c, "a")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "UNAUTHORIZED")
+
+	_, err = NewService("ftp://example.com", mockToken)
+	require.Error(t, err)
+}
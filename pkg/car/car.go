@@ -0,0 +1,134 @@
+package car
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// Version denotes the version of the CAR format supported by this package.
+const Version = 1
+
+// maxSectionSize limits the size of a single section to guard against corrupted
+// or malicious inputs.
+const maxSectionSize = 32 << 20
+
+// Header denotes the header of a CAR file.
+type Header struct {
+	Roots   []cid.Cid
+	Version uint64
+}
+
+func init() {
+	cbor.RegisterCborType(Header{})
+}
+
+// Writer writes blocks to an underlying stream in CARv1 format.
+type Writer struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewWriter creates a Writer and writes the header with given roots.
+func NewWriter(w io.Writer, roots []cid.Cid) (*Writer, error) {
+	data, err := cbor.DumpObject(&Header{Roots: roots, Version: Version})
+	if err != nil {
+		return nil, err
+	}
+
+	cw := &Writer{w: w}
+	if err := cw.writeSection(data); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// Put writes a block with given cid and raw data.
+func (cw *Writer) Put(c cid.Cid, data []byte) error {
+	return cw.writeSection(c.Bytes(), data)
+}
+
+func (cw *Writer) writeSection(parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	n := binary.PutUvarint(cw.buf[:], uint64(size))
+	if _, err := cw.w.Write(cw.buf[:n]); err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+		if _, err := cw.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader reads blocks from a stream in CARv1 format.
+type Reader struct {
+	r      *bufio.Reader
+	Header Header
+}
+
+// NewReader creates a Reader and reads the header from the stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &Reader{r: bufio.NewReader(r)}
+
+	data, err := cr.readSection()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cbor.DecodeInto(data, &cr.Header); err != nil {
+		return nil, err
+	}
+	if cr.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported car version: %d", cr.Header.Version)
+	}
+
+	return cr, nil
+}
+
+// Next reads the next block. It returns io.EOF if there are no more blocks.
+func (cr *Reader) Next() (cid.Cid, []byte, error) {
+	data, err := cr.readSection()
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	n, c, err := cid.CidFromBytes(data)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	return c, data[n:], nil
+}
+
+func (cr *Reader) readSection() ([]byte, error) {
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxSectionSize {
+		return nil, fmt.Errorf("car section too large: %d bytes", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package car
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func mockBlock(t *testing.T, data string) (cid.Cid, []byte) {
+	t.Helper()
+
+	hash, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, hash), []byte(data)
+}
+
+func TestReadWrite(t *testing.T) {
+	c1, d1 := mockBlock(t, "foo")
+	c2, d2 := mockBlock(t, "bar")
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []cid.Cid{c1})
+	require.NoError(t, err)
+	require.NoError(t, w.Put(c1, d1))
+	require.NoError(t, w.Put(c2, d2))
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{c1}, r.Header.Roots)
+
+	c, data, err := r.Next()
+	require.NoError(t, err)
+	require.True(t, c.Equals(c1))
+	require.Equal(t, d1, data)
+
+	c, data, err = r.Next()
+	require.NoError(t, err)
+	require.True(t, c.Equals(c2))
+	require.Equal(t, d2, data)
+
+	_, _, err = r.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReadTruncated(t *testing.T) {
+	c1, d1 := mockBlock(t, "foo")
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []cid.Cid{c1})
+	require.NoError(t, err)
+	require.NoError(t, w.Put(c1, d1))
+
+	data := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(data[:len(data)-1]))
+	require.NoError(t, err)
+
+	_, _, err = r.Next()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
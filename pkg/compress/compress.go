@@ -0,0 +1,139 @@
+// Package compress provides stream compressors used to compress the content of
+// files before adding them to ipfs.
+//
+// gzip and zstd are built in. Other algorithms can be plugged in by registering
+// an Instance wrapping their implementation.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Gzip is the name of the gzip compressor.
+	Gzip = "gzip"
+
+	// Zstd is the name of the zstd compressor.
+	Zstd = "zstd"
+)
+
+// Instance provides both compressing and decompressing methods.
+type Instance interface {
+	// Compress returns a writer which compresses data written to it into w. The
+	// output must be deterministic for the same input, so that the cid of the
+	// compressed content can be reproduced. The writer must be closed to flush
+	// the compressed data.
+	Compress(w io.Writer) (io.WriteCloser, error)
+
+	// Decompress returns a reader which decompresses data read from r.
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	mu        sync.RWMutex
+	instances = map[string]Instance{
+		Gzip: gzipInstance{},
+		Zstd: zstdInstance{},
+	}
+)
+
+// Register registers the compressor with given name, replacing the one with the
+// same name. The name is recorded with the compressed content, so the same
+// compressor must be registered to read it back.
+func Register(name string, c Instance) {
+	mu.Lock()
+	defer mu.Unlock()
+	instances[name] = c
+}
+
+// Get returns the compressor with given name.
+func Get(name string) (Instance, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok := instances[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm %q", name)
+	}
+	return c, nil
+}
+
+// incompressibleExts are extensions of formats which are compressed already.
+var incompressibleExts = map[string]bool{
+	".7z": true, ".bz2": true, ".gz": true, ".tgz": true, ".xz": true,
+	".zip": true, ".zst": true, ".lz4": true, ".rar": true, ".br": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".avif": true, ".mp3": true, ".aac": true, ".ogg": true,
+	".opus": true, ".flac": true, ".mp4": true, ".m4a": true, ".m4v": true,
+	".mkv": true, ".mov": true, ".webm": true, ".avi": true, ".docx": true,
+	".xlsx": true, ".pptx": true, ".jar": true, ".apk": true, ".woff2": true,
+}
+
+// incompressibleTypes are prefixes of content types sniffed from formats which
+// are compressed already.
+var incompressibleTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff2",
+	"application/zip",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/wasm",
+}
+
+// Compressible reports whether content with given name and leading bytes is
+// worth compressing. Content which is compressed already, judged by the
+// extension of the name or by the content type sniffed from head, is not.
+func Compressible(name string, head []byte) bool {
+	if incompressibleExts[strings.ToLower(path.Ext(name))] {
+		return false
+	}
+	if len(head) == 0 {
+		return true
+	}
+
+	ctype := http.DetectContentType(head)
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(ctype, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// gzipInstance compresses with gzip. Headers are left empty, so the output only
+// depends on the input.
+type gzipInstance struct{}
+
+func (gzipInstance) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+}
+
+func (gzipInstance) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdInstance compresses with zstd. The content is encoded by a single goroutine,
+// so the output only depends on the input.
+type zstdInstance struct{}
+
+func (zstdInstance) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdInstance) Decompress(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
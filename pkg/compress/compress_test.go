@@ -0,0 +1,111 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func compressBytes(t *testing.T, c Instance, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzip(t *testing.T) {
+	testInstance(t, Gzip)
+}
+
+func TestZstd(t *testing.T) {
+	testInstance(t, Zstd)
+}
+
+func testInstance(t *testing.T, name string) {
+	c, err := Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(strings.Repeat("log line\n", 1000))
+	compressed := compressBytes(t, c, data)
+	if len(compressed) >= len(data) {
+		t.Errorf("expect compressed size less than %d, but get %d", len(data), len(compressed))
+	}
+	if !bytes.Equal(compressed, compressBytes(t, c, data)) {
+		t.Errorf("expect deterministic output")
+	}
+
+	r, err := c.Decompress(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, out) {
+		t.Errorf("expect decompressed data equals to the input")
+	}
+}
+
+type nopInstance struct{}
+
+func (nopInstance) Compress(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (nopInstance) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestRegister(t *testing.T) {
+	if _, err := Get("nop"); err == nil {
+		t.Errorf("expect error for unknown algorithm")
+	}
+
+	Register("nop", nopInstance{})
+	if _, err := Get("nop"); err != nil {
+		t.Errorf("expect registered algorithm, but get %v", err)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	testcases := []struct {
+		name   string
+		head   []byte
+		expect bool
+	}{
+		{"app.log", []byte("2020-01-01 INFO started\n"), true},
+		{"empty", nil, true},
+		{"archive.tar.gz", nil, false},
+		{"archive.tar.zst", nil, false},
+		{"photo.JPG", nil, false},
+		{"noext", []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), false},
+		{"noext", []byte("\x89PNG\x0d\x0a\x1a\x0a"), false},
+	}
+
+	for _, tc := range testcases {
+		if got := Compressible(tc.name, tc.head); got != tc.expect {
+			t.Errorf("%s: expect %v, but get %v", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package syncer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/meowdada/ipfstor/pkg/codec"
+)
+
+// record denotes the version of a file at the time it was synchronized last.
+// ModTime and Size describe the local file while Cid describes the remote one.
+type record struct {
+	Cid     cid.Cid
+	Size    int64
+	ModTime int64
+}
+
+// state is the database recording the last synchronized version of each key.
+type state struct {
+	path    string
+	Records map[string]record
+}
+
+// loadState loads the state database from given path. An empty state is
+// returned if the database does not present yet.
+func loadState(fpath string) (*state, error) {
+	s := &state{
+		path:    fpath,
+		Records: make(map[string]record),
+	}
+
+	data, err := ioutil.ReadFile(fpath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := codec.Gob{}
+	if err := decoder.Unmarshal(data, &s.Records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save persists the state database. The database is written to a temporary
+// file first and then renamed, so that a crash never leaves it truncated.
+func (s *state) save() error {
+	encoder := codec.Gob{}
+	data, err := encoder.Marshal(s.Records)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
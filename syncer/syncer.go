@@ -0,0 +1,570 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/meowdada/ipfstor/drive"
+	"github.com/meowdada/ipfstor/options"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+
+	// compareChunkSize is the size of the chunks to compare local and remote
+	// content by.
+	compareChunkSize = 32 * 1024
+
+	// debounce is the quiet period to wait for after a local change before
+	// synchronizing, so that a burst of writes is handled at once.
+	debounce = 500 * time.Millisecond
+
+	// reservedPrefix is the prefix of the names used by the syncer itself, such
+	// as the state database and temporary files. They are never synchronized.
+	reservedPrefix = ".ipfstor-sync"
+)
+
+// watcher signals changes of a local directory tree.
+type watcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+// Syncer keeps a local directory and the files under a prefix of a drive in
+// sync in both directions. The last synchronized version of each file is kept
+// in a state database, which is used to determine which side has changed.
+type Syncer struct {
+	drive    drive.Instance
+	prefix   string
+	dir      string
+	interval time.Duration
+	conflict options.ConflictPolicy
+	ignore   []string
+	state    *state
+	logger   *zap.Logger
+}
+
+// New creates a syncer that synchronizes localDir with the files whose keys
+// start with prefix in the drive.
+func New(d drive.Instance, prefix, localDir string, opts ...*options.SyncOptions) (*Syncer, error) {
+	if d == nil {
+		return nil, errors.New("accepts only non-nil drive instance")
+	}
+
+	opt := options.MergeSyncOptions(opts...)
+
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", localDir)
+	}
+
+	statePath := filepath.Join(localDir, reservedPrefix)
+	if opt.StatePath != nil {
+		statePath = *opt.StatePath
+	}
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Syncer{
+		drive:    d,
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		dir:      filepath.Clean(localDir),
+		interval: defaultInterval,
+		conflict: options.ConflictKeepBoth,
+		ignore:   opt.Ignore,
+		state:    st,
+		logger:   opt.Logger,
+	}
+	if len(s.prefix) != 0 {
+		s.prefix += "/"
+	}
+	if opt.Interval != nil {
+		s.interval = *opt.Interval
+	}
+	if opt.Conflict != nil {
+		s.conflict = *opt.Conflict
+	}
+	if s.logger == nil {
+		s.logger = zap.NewNop()
+	}
+
+	return s, nil
+}
+
+// Run synchronizes both sides until the context is done. Local changes are
+// picked up by watching the directory, while remote changes replicated into
+// the drive are picked up by polling it periodically. A failed pass is logged
+// and retried by the next one, so that a single bad file or a transient error
+// does not stop the synchronization.
+func (s *Syncer) Run(ctx context.Context) error {
+	w, err := newWatcher(s.dir)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	s.syncOnce(ctx)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.Events():
+			pending = time.After(debounce)
+			continue
+		case <-pending:
+			pending = nil
+		case <-ticker.C:
+		}
+
+		s.syncOnce(ctx)
+	}
+}
+
+// syncOnce performs a single synchronization pass and logs its failure.
+func (s *Syncer) syncOnce(ctx context.Context) {
+	if err := s.Sync(ctx); err != nil && ctx.Err() == nil {
+		s.logger.Warn("failed to synchronize", zap.String("dir", s.dir), zap.String("prefix", s.prefix), zap.Error(err))
+	}
+}
+
+// Sync performs a single synchronization pass. Every change is attempted even
+// if some of them fail, and the first error is returned.
+func (s *Syncer) Sync(ctx context.Context) error {
+	local, err := s.scanLocal()
+	if err != nil {
+		return err
+	}
+
+	remote, err := s.scanRemote(ctx)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, a := range plan(local, remote, s.state.Records, s.conflict) {
+		if err := ctx.Err(); err != nil {
+			first = err
+			break
+		}
+		if err := s.apply(ctx, a); err != nil && first == nil {
+			first = errors.Wrapf(err, "sync %s", a.key)
+		}
+	}
+
+	if err := s.state.save(); err != nil && first == nil {
+		first = err
+	}
+
+	return first
+}
+
+// localFile denotes a regular file found in the local directory.
+type localFile struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+func (s *Syncer) scanLocal() (map[string]localFile, error) {
+	files := make(map[string]localFile)
+
+	err := filepath.Walk(s.dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.dir, fpath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if s.ignored(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() {
+			files[s.prefix+rel] = localFile{
+				path:    fpath,
+				size:    info.Size(),
+				modTime: info.ModTime().UnixNano(),
+			}
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+func (s *Syncer) scanRemote(ctx context.Context) (map[string]drive.File, error) {
+	lr, err := s.drive.List(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]drive.File)
+	for _, f := range lr.Files() {
+		if !strings.HasPrefix(f.Key, s.prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(f.Key, s.prefix)
+		if _, err := s.localPath(f.Key); err != nil || s.ignored(rel) {
+			continue
+		}
+		files[f.Key] = f
+	}
+
+	return files, nil
+}
+
+func (s *Syncer) ignored(rel string) bool {
+	name := path.Base(rel)
+	if strings.HasPrefix(name, reservedPrefix) {
+		return true
+	}
+
+	for _, pattern := range s.ignore {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// localPath maps a key to its path in the local directory.
+func (s *Syncer) localPath(key string) (string, error) {
+	rel := strings.TrimPrefix(key, s.prefix)
+	if len(rel) == 0 {
+		return "", fmt.Errorf("key %q has no relative path", key)
+	}
+
+	fpath := filepath.Join(s.dir, filepath.FromSlash(rel))
+	if !strings.HasPrefix(fpath, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q escapes the local directory", key)
+	}
+	return fpath, nil
+}
+
+type op int
+
+const (
+	opPush op = iota
+	opPull
+	opRemoveLocal
+	opRemoveRemote
+	opForget
+	opConflict
+)
+
+// action denotes a change to be applied on either side for a single key.
+type action struct {
+	key    string
+	op     op
+	local  localFile
+	remote drive.File
+
+	// expected is the Cid the remote file is expected to have while pushing
+	// or removing it, or cid.Undef if it is expected to be absent.
+	expected cid.Cid
+}
+
+// plan compares both sides against the records of the last synchronization
+// and determines the actions to take.
+func plan(local map[string]localFile, remote map[string]drive.File, records map[string]record, policy options.ConflictPolicy) []action {
+	keys := make(map[string]struct{})
+	for k := range local {
+		keys[k] = struct{}{}
+	}
+	for k := range remote {
+		keys[k] = struct{}{}
+	}
+	for k := range records {
+		keys[k] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var actions []action
+	for _, key := range sorted {
+		l, lok := local[key]
+		r, rok := remote[key]
+		rec, sok := records[key]
+
+		localChanged := lok != sok || (lok && (l.size != rec.Size || l.modTime != rec.ModTime))
+		remoteChanged := rok != sok || (rok && !r.Cid.Equals(rec.Cid))
+
+		a := action{key: key, local: l, remote: r}
+		if rok {
+			a.expected = r.Cid
+		}
+
+		switch {
+		case !localChanged && !remoteChanged:
+			continue
+		case localChanged && !remoteChanged:
+			a.op = opPush
+			if !lok {
+				a.op = opRemoveRemote
+			}
+		case !localChanged && remoteChanged:
+			a.op = opPull
+			if !rok {
+				a.op = opRemoveLocal
+			}
+		case !lok && !rok:
+			a.op = opForget
+		case !lok:
+			// A file removed on one side but modified on the other one is
+			// restored with the modification.
+			a.op = opPull
+		case !rok:
+			a.op = opPush
+		default:
+			a.op = opConflict
+		}
+
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+func (s *Syncer) apply(ctx context.Context, a action) error {
+	switch a.op {
+	case opPush:
+		return s.push(ctx, a.key, a.local, a.expected)
+	case opPull:
+		return s.pull(ctx, a.remote)
+	case opRemoveLocal:
+		fpath, err := s.localPath(a.key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(s.state.Records, a.key)
+	case opRemoveRemote:
+		rec := s.state.Records[a.key]
+		err := s.drive.Remove(ctx, a.key, options.Remove().SetIfMatch(rec.Cid))
+		if errors.Is(err, drive.ErrPreconditionFailed) {
+			// The remote file has been changed meanwhile, leave it to the
+			// next pass.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		delete(s.state.Records, a.key)
+	case opForget:
+		delete(s.state.Records, a.key)
+	case opConflict:
+		return s.resolve(ctx, a)
+	}
+	return nil
+}
+
+func (s *Syncer) push(ctx context.Context, key string, l localFile, expected cid.Cid) error {
+	opt := options.Add()
+	if expected.Defined() {
+		opt.SetIfMatch(expected)
+	} else {
+		opt.SetIfNotExists(true)
+	}
+
+	f, err := s.drive.AddFile(ctx, key, l.path, opt)
+	if errors.Is(err, drive.ErrPreconditionFailed) {
+		// The remote file has been changed meanwhile, leave it to the next pass.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.state.Records[key] = record{Cid: f.Cid, Size: l.size, ModTime: l.modTime}
+	return nil
+}
+
+func (s *Syncer) pull(ctx context.Context, f drive.File) error {
+	fpath, err := s.localPath(f.Key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return err
+	}
+
+	rc, err := s.drive.Get(ctx, f.Key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Write to a temporary file first, so that the local file is replaced
+	// atomically and never observed half-written.
+	tmp, err := ioutil.TempFile(filepath.Dir(fpath), reservedPrefix+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, rc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if mtime, err := time.Parse(time.RFC1123, f.ModTime); err == nil {
+		if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), fpath); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return err
+	}
+
+	s.state.Records[f.Key] = record{Cid: f.Cid, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	return nil
+}
+
+// resolve resolves a file changed on both sides according to the conflict
+// policy. Nothing but the record is updated if both sides end up with the
+// same content.
+func (s *Syncer) resolve(ctx context.Context, a action) error {
+	same, err := s.sameContent(ctx, a.local, a.remote)
+	if err != nil {
+		return err
+	}
+	if same {
+		s.state.Records[a.key] = record{Cid: a.remote.Cid, Size: a.local.size, ModTime: a.local.modTime}
+		return nil
+	}
+
+	switch s.conflict {
+	case options.ConflictPreferLocal:
+		return s.push(ctx, a.key, a.local, a.expected)
+	case options.ConflictPreferRemote:
+		return s.pull(ctx, a.remote)
+	}
+
+	// Keep both: move the local file aside as a conflict copy and upload it,
+	// then download the remote file to the original path.
+	copyKey := conflictKey(a.key, time.Now())
+	copyPath, err := s.localPath(copyKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(a.local.path, copyPath); err != nil {
+		return err
+	}
+
+	l := a.local
+	l.path = copyPath
+	if err := s.push(ctx, copyKey, l, cid.Undef); err != nil {
+		return err
+	}
+
+	return s.pull(ctx, a.remote)
+}
+
+// sameContent reports whether the local file has the same content as the file in
+// the drive. The contents are compared chunk by chunk, so that neither of them is
+// held in memory and the download stops at the first difference.
+func (s *Syncer) sameContent(ctx context.Context, l localFile, f drive.File) (bool, error) {
+	if l.size != f.Size {
+		return false, nil
+	}
+
+	local, err := os.Open(l.path)
+	if err != nil {
+		return false, err
+	}
+	defer local.Close()
+
+	rc, err := s.drive.Get(ctx, f.Key)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	return sameStream(local, rc)
+}
+
+// sameStream reports whether both readers yield the same bytes.
+func sameStream(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+
+	for {
+		na, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nb, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		// A short read means the end of both streams, since they are equal so far.
+		if errA != nil || errB != nil {
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
+
+// conflictKey derives the key of the conflict copy of given key, such as
+// "dir/name.conflict-20200102T030405Z.txt" for "dir/name.txt".
+func conflictKey(key string, t time.Time) string {
+	ext := path.Ext(path.Base(key))
+	base := strings.TrimSuffix(key, ext)
+	return fmt.Sprintf("%s.conflict-%s%s", base, t.UTC().Format("20060102T150405Z"), ext)
+}
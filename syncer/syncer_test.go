@@ -0,0 +1,279 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipfsCore "github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	mock "github.com/ipfs/go-ipfs/core/mock"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/meowdada/ipfstor/drive"
+	"github.com/meowdada/ipfstor/options"
+	"github.com/stretchr/testify/require"
+)
+
+func mockTempDir(t *testing.T, name string) (string, func()) {
+	t.Helper()
+
+	path, err := ioutil.TempDir("", name)
+	require.NoError(t, err)
+
+	cleanup := func() { os.RemoveAll(path) }
+	return path, cleanup
+}
+
+func mockDrive(t *testing.T) (drive.Instance, func()) {
+	t.Helper()
+
+	ctx := context.Background()
+	dbPath, dbPathClean := mockTempDir(t, "db")
+
+	core, err := ipfsCore.NewNode(ctx, &ipfsCore.BuildCfg{
+		Online: true,
+		Host:   mock.MockHostOption(mocknet.New(ctx)),
+		ExtraOpts: map[string]bool{
+			"pubsub": true,
+		},
+	})
+	require.NoError(t, err)
+
+	api, err := coreapi.NewCoreAPI(core)
+	require.NoError(t, err)
+
+	opts := options.OpenDrive().SetCreate(true).SetDirectory(dbPath)
+	d, err := drive.Open(ctx, api, "sync", opts)
+	require.NoError(t, err)
+
+	return d, func() {
+		d.Close(ctx)
+		core.Close()
+		dbPathClean()
+	}
+}
+
+func readFile(t *testing.T, fpath string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(fpath)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func readKey(t *testing.T, d drive.Instance, key string) string {
+	t.Helper()
+
+	rc, err := d.Get(context.Background(), key)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func mockCid(t *testing.T, s string) cid.Cid {
+	t.Helper()
+
+	c, err := cid.Decode(s)
+	require.NoError(t, err)
+	return c
+}
+
+func TestPlan(t *testing.T) {
+	c1 := mockCid(t, "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	c2 := mockCid(t, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+
+	synced := localFile{path: "a", size: 1, modTime: 1}
+	modified := localFile{path: "a", size: 2, modTime: 2}
+	records := map[string]record{"a": {Cid: c1, Size: 1, ModTime: 1}}
+
+	testcases := []struct {
+		description string
+		local       map[string]localFile
+		remote      map[string]drive.File
+		records     map[string]record
+		expect      []op
+	}{
+		{
+			"Nothing changed",
+			map[string]localFile{"a": synced},
+			map[string]drive.File{"a": {Key: "a", Cid: c1}},
+			records,
+			nil,
+		},
+		{
+			"Local file modified",
+			map[string]localFile{"a": modified},
+			map[string]drive.File{"a": {Key: "a", Cid: c1}},
+			records,
+			[]op{opPush},
+		},
+		{
+			"Local file removed",
+			nil,
+			map[string]drive.File{"a": {Key: "a", Cid: c1}},
+			records,
+			[]op{opRemoveRemote},
+		},
+		{
+			"Remote file modified",
+			map[string]localFile{"a": synced},
+			map[string]drive.File{"a": {Key: "a", Cid: c2}},
+			records,
+			[]op{opPull},
+		},
+		{
+			"Remote file removed",
+			map[string]localFile{"a": synced},
+			nil,
+			records,
+			[]op{opRemoveLocal},
+		},
+		{
+			"Both modified",
+			map[string]localFile{"a": modified},
+			map[string]drive.File{"a": {Key: "a", Cid: c2}},
+			records,
+			[]op{opConflict},
+		},
+		{
+			"Removed locally but modified remotely",
+			nil,
+			map[string]drive.File{"a": {Key: "a", Cid: c2}},
+			records,
+			[]op{opPull},
+		},
+		{
+			"Both removed",
+			nil,
+			nil,
+			records,
+			[]op{opForget},
+		},
+		{
+			"New files on both sides",
+			map[string]localFile{"b": synced},
+			map[string]drive.File{"c": {Key: "c", Cid: c2}},
+			nil,
+			[]op{opPush, opPull},
+		},
+	}
+
+	for _, tc := range testcases {
+		actions := plan(tc.local, tc.remote, tc.records, options.ConflictKeepBoth)
+
+		var ops []op
+		for _, a := range actions {
+			ops = append(ops, a.op)
+		}
+		require.Equal(t, tc.expect, ops, tc.description)
+	}
+}
+
+func TestConflictKey(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, "dir/name.conflict-20200102T030405Z.txt", conflictKey("dir/name.txt", ts))
+	require.Equal(t, "name.conflict-20200102T030405Z", conflictKey("name", ts))
+}
+
+func TestSameStream(t *testing.T) {
+	chunk := strings.Repeat("x", compareChunkSize)
+
+	for _, c := range []struct {
+		a, b string
+		same bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{chunk + "abc", chunk + "abc", true},
+		{chunk, chunk, true},
+		{chunk, chunk + "a", false},
+		{chunk + "abc", chunk + "abd", false},
+		{"abc", "ab", false},
+	} {
+		same, err := sameStream(strings.NewReader(c.a), strings.NewReader(c.b))
+		require.NoError(t, err)
+		require.Equal(t, c.same, same, "%d and %d bytes", len(c.a), len(c.b))
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d, cleanup := mockDrive(t)
+	defer cleanup()
+
+	dir, dirClean := mockTempDir(t, "sync")
+	defer dirClean()
+
+	s, err := New(d, "docs", dir)
+	require.NoError(t, err)
+
+	// Local files are pushed.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("local"), 0644))
+	require.NoError(t, s.Sync(ctx))
+	require.Equal(t, "local", readKey(t, d, "docs/a.txt"))
+
+	// Remote files are pulled.
+	_, err = d.Add(ctx, "docs/sub/b.txt", bytes.NewBufferString("remote"))
+	require.NoError(t, err)
+	require.NoError(t, s.Sync(ctx))
+	require.Equal(t, "remote", readFile(t, filepath.Join(dir, "sub", "b.txt")))
+
+	// Files removed remotely are removed locally.
+	require.NoError(t, d.Remove(ctx, "docs/sub/b.txt"))
+	require.NoError(t, s.Sync(ctx))
+	_, err = os.Stat(filepath.Join(dir, "sub", "b.txt"))
+	require.True(t, os.IsNotExist(err))
+
+	// Files changed on both sides are kept both.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("local change"), 0644))
+	_, err = d.Add(ctx, "docs/a.txt", bytes.NewBufferString("remote change"))
+	require.NoError(t, err)
+	require.NoError(t, s.Sync(ctx))
+	require.Equal(t, "remote change", readFile(t, filepath.Join(dir, "a.txt")))
+
+	lr, err := d.List(ctx, "docs/")
+	require.NoError(t, err)
+
+	var copies []string
+	for _, f := range lr.Files() {
+		if strings.HasPrefix(f.Key, "docs/a.conflict-") {
+			copies = append(copies, f.Key)
+		}
+	}
+	require.Len(t, copies, 1)
+	require.Equal(t, "local change", readKey(t, d, copies[0]))
+	require.Equal(t, "local change", readFile(t, filepath.Join(dir, strings.TrimPrefix(copies[0], "docs/"))))
+
+	// Nothing is left to do once both sides are in sync.
+	records := len(s.state.Records)
+	require.NoError(t, s.Sync(ctx))
+	require.Len(t, s.state.Records, records)
+	require.Len(t, plan(mustScanLocal(t, s), mustScanRemote(ctx, t, s), s.state.Records, s.conflict), 0)
+}
+
+func mustScanLocal(t *testing.T, s *Syncer) map[string]localFile {
+	t.Helper()
+
+	local, err := s.scanLocal()
+	require.NoError(t, err)
+	return local
+}
+
+func mustScanRemote(ctx context.Context, t *testing.T, s *Syncer) map[string]drive.File {
+	t.Helper()
+
+	remote, err := s.scanRemote(ctx)
+	require.NoError(t, err)
+	return remote
+}
@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF |
+	unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// inotifyWatcher watches a directory tree with inotify.
+type inotifyWatcher struct {
+	fd     int
+	f      *os.File
+	paths  map[int]string
+	events chan struct{}
+}
+
+func newWatcher(dir string) (watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		paths:  make(map[int]string),
+		events: make(chan struct{}, 1),
+	}
+
+	if err := w.addRecursive(dir); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.f.Close()
+}
+
+// addRecursive watches the directory and all of its sub-directories, since
+// inotify does not watch a directory tree recursively by itself.
+func (w *inotifyWatcher) addRecursive(dir string) error {
+	return filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, fpath, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.paths[wd] = fpath
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) loop() {
+	buf := make([]byte, 4096*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + unix.SizeofInotifyEvent
+			end := start + int(ev.Len)
+			name := strings.TrimRight(string(buf[start:end]), "\x00")
+			off = end
+
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				delete(w.paths, int(ev.Wd))
+				continue
+			}
+
+			// Start watching directories created or moved into the tree.
+			if ev.Mask&unix.IN_ISDIR != 0 && ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+				if dir, ok := w.paths[int(ev.Wd)]; ok {
+					_ = w.addRecursive(filepath.Join(dir, name))
+				}
+			}
+
+			w.notify()
+		}
+	}
+}
+
+// notify signals a change without blocking. Consecutive changes are coalesced
+// into a single signal until it is consumed.
+func (w *inotifyWatcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package syncer
+
+// pollWatcher never signals. Local changes are picked up by the periodic
+// synchronization instead.
+type pollWatcher struct{}
+
+func newWatcher(dir string) (watcher, error) {
+	return pollWatcher{}, nil
+}
+
+func (pollWatcher) Events() <-chan struct{} {
+	return nil
+}
+
+func (pollWatcher) Close() error {
+	return nil
+}